@@ -0,0 +1,29 @@
+package archive
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/antipasta/wildhaiku/haiku"
+	"github.com/gookit/color"
+)
+
+// ConsoleSink echoes archived haikus in color, separately from the log
+type ConsoleSink struct {
+	Writer io.Writer
+	Color  color.Color
+}
+
+// NewConsoleSink returns a ConsoleSink printing to stdout in cyan
+func NewConsoleSink() *ConsoleSink {
+	return &ConsoleSink{Writer: os.Stdout, Color: color.Cyan}
+}
+
+// Print is an ArchivedFunc printing the permalink of the post, followed by each of its haikus in color
+func (cs *ConsoleSink) Print(out *haiku.Output) {
+	fmt.Fprintf(cs.Writer, "%s\n", out.Post.Permalink)
+	for _, foundHaiku := range out.Haikus {
+		fmt.Fprintf(cs.Writer, "%s\n\n", cs.Color.Sprint(foundHaiku.String()))
+	}
+}
@@ -1,20 +1,25 @@
-/*Package archive is used to save Haikus found within Tweets to disk
+/*Package archive is used to save Haikus found within Posts to disk
  */
 package archive
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"io/ioutil"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/antipasta/wildhaiku/config"
+	"github.com/antipasta/wildhaiku/logging"
 	"github.com/antipasta/wildhaiku/haiku"
+	"github.com/antipasta/wildhaiku/source"
 	"github.com/antipasta/wildhaiku/syllable"
-	"github.com/gookit/color"
 	"github.com/pkg/errors"
 )
 
@@ -40,13 +45,75 @@ var suffixBlacklist = map[string]bool{
 	"your": true,
 }
 
+// FilterReason is why a found poem was not archived, see DiskArchiver.OnFiltered
+type FilterReason string
+
+const (
+	// BelowMinScore is a poem whose score total is below config MinScore
+	BelowMinScore FilterReason = "min_score"
+	// BlacklistedSuffix is a poem ending on a word in suffixBlacklist
+	BlacklistedSuffix FilterReason = "suffix"
+)
+
+// FilterFunc is called with every poem that is not archived, and why
+type FilterFunc func(reason FilterReason, poem syllable.Poem)
+
+// ArchivedFunc is called with every Output written to the archive, holding only the haikus that were archived
+type ArchivedFunc func(out *haiku.Output)
+
+// deletedIDsKept is how many deleted post IDs are remembered, enough to cover a full processing channel of a source and a full ArchiveChannel
+const deletedIDsKept = 20000
+
+// recentIDs is a set of the most recently added IDs, forgetting the oldest once it holds size of them
+type recentIDs struct {
+	ids  map[string]bool
+	ring []string
+	next int
+}
+
+func newRecentIDs(size int) *recentIDs {
+	return &recentIDs{ids: make(map[string]bool, size), ring: make([]string, 0, size)}
+}
+
+// add adds id to the set, forgetting the oldest ID if the set is full
+func (r *recentIDs) add(id string) {
+	if r.ids[id] || cap(r.ring) == 0 {
+		return
+	}
+	if len(r.ring) < cap(r.ring) {
+		r.ring = append(r.ring, id)
+	} else {
+		delete(r.ids, r.ring[r.next])
+		r.ring[r.next] = id
+		r.next = (r.next + 1) % len(r.ring)
+	}
+	r.ids[id] = true
+}
+
+// contains checks if id is one of the IDs in the set
+func (r *recentIDs) contains(id string) bool {
+	return r.ids[id]
+}
+
 // DiskArchiver receives *haiku.Output over a channel and writes to disk
 type DiskArchiver struct {
 	ArchiveChannel chan *haiku.Output
-	outFile        *os.File
-	outFilePath    string
-	symlinkPath    string
-	Config         *config.WildHaiku
+	// DeleteChannel, if set, receives deleted posts whose haikus are removed from the output file
+	DeleteChannel <-chan *source.Deletion
+	outFile       *os.File
+	outFilePath   string
+	symlinkPath   string
+	Config        *config.WildHaiku
+	// archivedIDs are the IDs of posts written to the output file
+	archivedIDs map[string]bool
+	// deletedIDs are the IDs of recently deleted posts, so that a post still queued for processing or archiving when its deletion arrives is never written
+	deletedIDs *recentIDs
+	// OnFiltered, if set, is called with every poem that is filtered out instead of archived
+	OnFiltered FilterFunc
+	// OnArchived, if set, is called after each Output is written, ie with ConsoleSink.Print to echo haikus as they are found
+	OnArchived ArchivedFunc
+	// Logger is where files written and errors are logged, defaults to slog.Default()
+	Logger *slog.Logger
 }
 
 // NewDiskArchiver creates an instance of DiskArchiver, errors if it cannot access path specified in config.OutputPath
@@ -64,19 +131,27 @@ func NewDiskArchiver(cfg *config.WildHaiku) (*DiskArchiver, error) {
 
 	filePath := filepath.Join(absOutPath, fileName)
 	symLink := filepath.Join(absOutPath, "current.json")
-	return &DiskArchiver{Config: cfg, ArchiveChannel: archiveChan, outFilePath: filePath, symlinkPath: symLink}, nil
+	return &DiskArchiver{Config: cfg, ArchiveChannel: archiveChan, outFilePath: filePath, symlinkPath: symLink, archivedIDs: map[string]bool{}, deletedIDs: newRecentIDs(deletedIDsKept), Logger: slog.Default()}, nil
 }
 
 func (da *DiskArchiver) output(out *haiku.Output) error {
 	if len(out.Haikus) == 0 {
 		return nil
 	}
-	filteredHaikus := []syllable.Haiku{}
+	if da.deletedIDs.contains(out.Post.ID) {
+		logging.OrDefault(da.Logger).Info("Skipping haikus of deleted post", "id", out.Post.ID)
+		return nil
+	}
+	filteredHaikus := []syllable.Poem{}
 	for _, foundHaiku := range out.Haikus {
+		if foundHaiku.Score != nil && foundHaiku.Score.Total < da.Config.MinScore {
+			da.filtered(BelowMinScore, foundHaiku)
+			continue
+		}
 		if !suffixBlacklist[strings.ToLower(foundHaiku.FinalWord())] {
 			filteredHaikus = append(filteredHaikus, foundHaiku)
-			log.Printf("https://twitter.com/%s/status/%s", out.Tweet.User.ScreenName, out.Tweet.IDStr)
-			color.Cyan.Printf("%s\n\n", foundHaiku.String())
+		} else {
+			da.filtered(BlacklistedSuffix, foundHaiku)
 		}
 
 	}
@@ -84,6 +159,11 @@ func (da *DiskArchiver) output(out *haiku.Output) error {
 		// filtered out some junk
 		return nil
 	}
+	if da.Config.SortByScore {
+		sort.SliceStable(filteredHaikus, func(i, j int) bool {
+			return filteredHaikus[i].Score != nil && (filteredHaikus[j].Score == nil || filteredHaikus[i].Score.Total > filteredHaikus[j].Score.Total)
+		})
+	}
 	out.Haikus = filteredHaikus
 	bytes, err := json.Marshal(out)
 	if err != nil {
@@ -93,17 +173,101 @@ func (da *DiskArchiver) output(out *haiku.Output) error {
 	if err != nil {
 		return errors.Wrapf(err, "Error writing to file %s", da.outFile.Name())
 	}
+	da.archivedIDs[out.Post.ID] = true
+	logging.OrDefault(da.Logger).Info("Archived haikus", "id", out.Post.ID, "permalink", out.Post.Permalink, "haikus", len(filteredHaikus))
+	if da.OnArchived != nil {
+		da.OnArchived(out)
+	}
+	return nil
+}
+
+// CheckWritable checks that the output file can be appended to or, before OutputLoop has created it, that files can be created in the output directory
+func (da *DiskArchiver) CheckWritable() error {
+	checkFile, err := os.OpenFile(da.outFilePath, os.O_WRONLY|os.O_APPEND, 0644)
+	if os.IsNotExist(err) {
+		checkFile, err = ioutil.TempFile(filepath.Dir(da.outFilePath), ".writable")
+		if err == nil {
+			defer os.Remove(checkFile.Name())
+		}
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Output file %s is not writable", da.outFilePath)
+	}
+	return checkFile.Close()
+}
+
+// filtered calls OnFiltered, if set
+func (da *DiskArchiver) filtered(reason FilterReason, poem syllable.Poem) {
+	if da.OnFiltered != nil {
+		da.OnFiltered(reason, poem)
+	}
+}
+
+// remove rewrites the output file without the haikus of a deleted post, and keeps its ID so that it is not archived later. Posts archived by previous runs, in other files, are not removed
+func (da *DiskArchiver) remove(deletion *source.Deletion) error {
+	da.deletedIDs.add(deletion.ID)
+	if !da.archivedIDs[deletion.ID] {
+		return nil
+	}
+	inFile, err := os.Open(da.outFilePath)
+	if err != nil {
+		return errors.Wrapf(err, "Error opening file %s", da.outFilePath)
+	}
+	defer inFile.Close()
+	tmpPath := da.outFilePath + ".tmp"
+	tmpFile, err := os.Create(tmpPath)
+	if err != nil {
+		return errors.Wrapf(err, "Error creating file %s", tmpPath)
+	}
+	defer tmpFile.Close()
+	reader := bufio.NewReader(inFile)
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			archived := struct{ Post *source.Post }{}
+			if json.Unmarshal(line, &archived) == nil && archived.Post != nil && archived.Post.ID == deletion.ID {
+				continue
+			}
+			if _, err := tmpFile.Write(line); err != nil {
+				return errors.Wrapf(err, "Error writing to file %s", tmpPath)
+			}
+		}
+		if readErr != nil {
+			break
+		}
+	}
+	if err = tmpFile.Close(); err != nil {
+		return errors.Wrapf(err, "Error closing file %s", tmpPath)
+	}
+	if err = os.Rename(tmpPath, da.outFilePath); err != nil {
+		return errors.Wrapf(err, "Error replacing %s with %s", da.outFilePath, tmpPath)
+	}
+	da.outFile.Close()
+	da.outFile, err = os.OpenFile(da.outFilePath, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "Error reopening file %s", da.outFilePath)
+	}
+	delete(da.archivedIDs, deletion.ID)
+	logging.OrDefault(da.Logger).Info("Removed haikus of post from archive", "id", deletion.ID, "reason", deletion.Reason)
 	return nil
 }
 
-//OutputLoop writes haiku.Output to disk, in a timestampped file based on when the function is first entered. Also creates a symlink current.json pointing at the file being written to
-func (da *DiskArchiver) OutputLoop() error {
+//OutputLoop writes haiku.Output to disk, in a timestampped file based on when the function is first entered. Also creates a symlink current.json pointing at the file being written to.
+//Returns once ArchiveChannel is closed and drained, or with ctx.Err() if ctx is done first. The file is synced to disk before returning
+func (da *DiskArchiver) OutputLoop(ctx context.Context) error {
 	var err error
 	da.outFile, err = os.Create(da.outFilePath)
 	if err != nil {
 		return errors.Wrapf(err, "Error creating file %s", da.outFilePath)
 	}
-	defer da.outFile.Close()
+	defer func() {
+		// remove may have reopened the file
+		err := da.outFile.Sync()
+		if err != nil {
+			logging.OrDefault(da.Logger).Error("Error syncing file", "path", da.outFilePath, "error", err)
+		}
+		da.outFile.Close()
+	}()
 	if _, err := os.Lstat(da.symlinkPath); err == nil {
 		err = os.Remove(da.symlinkPath)
 		if err != nil {
@@ -114,12 +278,49 @@ func (da *DiskArchiver) OutputLoop() error {
 	if err != nil {
 		return err
 	}
-	log.Printf("Writing to file %s (and symlink %s)", da.outFilePath, da.symlinkPath)
-	for tweet := range da.ArchiveChannel {
-		err = da.output(tweet)
-		if err != nil {
-			log.Printf("Got error %v when saving tweet %+v to disk, skipping", err, tweet)
+	logging.OrDefault(da.Logger).Info("Writing to file", "path", da.outFilePath, "symlink", da.symlinkPath)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case tweet, ok := <-da.ArchiveChannel:
+			if !ok {
+				da.drainDeletions()
+				return nil
+			}
+			err = da.output(tweet)
+			if err != nil {
+				logging.OrDefault(da.Logger).Error("Error saving post to disk, skipping", "id", tweet.Post.ID, "error", err)
+			}
+		case deletion, ok := <-da.DeleteChannel:
+			if !ok {
+				// source has stopped, keep archiving until ArchiveChannel is closed
+				da.DeleteChannel = nil
+				continue
+			}
+			da.removeLogged(deletion)
+		}
+	}
+}
+
+func (da *DiskArchiver) removeLogged(deletion *source.Deletion) {
+	err := da.remove(deletion)
+	if err != nil {
+		logging.OrDefault(da.Logger).Error("Error removing post from disk", "id", deletion.ID, "error", err)
+	}
+}
+
+// drainDeletions removes the posts of any deletions still buffered on DeleteChannel
+func (da *DiskArchiver) drainDeletions() {
+	for {
+		select {
+		case deletion, ok := <-da.DeleteChannel:
+			if !ok {
+				return
+			}
+			da.removeLogged(deletion)
+		default:
+			return
 		}
 	}
-	return nil
 }
@@ -0,0 +1,154 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/antipasta/wildhaiku/config"
+	"github.com/antipasta/wildhaiku/haiku"
+	"github.com/antipasta/wildhaiku/source"
+	"github.com/antipasta/wildhaiku/syllable"
+	"github.com/gookit/color"
+	"gopkg.in/antipasta/prose.v2"
+)
+
+func testOutput(id string, lastWord string) *haiku.Output {
+	line := syllable.Sentence{{Word: prose.Token{Text: lastWord}, Syllables: 1}}
+	return &haiku.Output{
+		Haikus: []syllable.Poem{{Lines: []syllable.Sentence{line, line, line}}},
+		Post:   &source.Post{ID: id, Text: lastWord},
+	}
+}
+
+func TestDeletions(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "wildhaiku-archive")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	da, err := NewDiskArchiver(&config.WildHaiku{OutputPath: tmpDir})
+	if err != nil {
+		t.Fatalf("Error creating disk archiver: %v", err)
+	}
+	// unbuffered, so that each send is read before the next
+	da.ArchiveChannel = make(chan *haiku.Output)
+	// an unset Logger falls back to the default logger
+	da.Logger = nil
+	deletions := make(chan *source.Deletion)
+	da.DeleteChannel = deletions
+	done := make(chan error)
+	go func() {
+		done <- da.OutputLoop(context.Background())
+	}()
+
+	da.ArchiveChannel <- testOutput("1", "pond")
+	da.ArchiveChannel <- testOutput("2", "frog")
+	da.ArchiveChannel <- testOutput("3", "splash")
+	deletions <- &source.Deletion{ID: "2", Reason: "delete"}
+	deletions <- &source.Deletion{ID: "never archived", Reason: "delete"}
+	// deleted while still queued, before it reached the archive
+	deletions <- &source.Deletion{ID: "5", Reason: "delete"}
+	da.ArchiveChannel <- testOutput("4", "water")
+	da.ArchiveChannel <- testOutput("5", "leaps")
+	close(da.ArchiveChannel)
+	if err = <-done; err != nil {
+		t.Fatalf("Error from output loop: %v", err)
+	}
+
+	outBytes, err := ioutil.ReadFile(filepath.Join(tmpDir, "current.json"))
+	if err != nil {
+		t.Fatalf("Error reading archive: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(outBytes)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 archived posts after deletion, got %v", lines)
+	}
+	for i, id := range []string{`"ID":"1"`, `"ID":"3"`, `"ID":"4"`} {
+		if !strings.Contains(lines[i], id) {
+			t.Errorf("Expected line %d to hold post %s, got %s", i, id, lines[i])
+		}
+	}
+	if da.archivedIDs["2"] {
+		t.Errorf("Expected deleted post to no longer be tracked as archived")
+	}
+}
+
+func TestRecentIDs(t *testing.T) {
+	recent := newRecentIDs(3)
+	for _, id := range []string{"1", "2", "2", "3", "4", "5"} {
+		recent.add(id)
+	}
+	if recent.contains("1") || recent.contains("2") || !recent.contains("3") || !recent.contains("5") {
+		t.Errorf("Expected only the 3 most recent IDs to be kept, got %v", recent.ids)
+	}
+	if len(recent.ids) != 3 || len(recent.ring) != 3 {
+		t.Errorf("Expected the set to stay at its size, got %d ids %d ring", len(recent.ids), len(recent.ring))
+	}
+}
+
+func TestFiltered(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "wildhaiku-archive")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	da, err := NewDiskArchiver(&config.WildHaiku{OutputPath: tmpDir, MinScore: 0.5})
+	if err != nil {
+		t.Fatalf("Error creating disk archiver: %v", err)
+	}
+	filtered := map[FilterReason]int{}
+	da.OnFiltered = func(reason FilterReason, poem syllable.Poem) {
+		filtered[reason]++
+	}
+	console := bytes.Buffer{}
+	da.OnArchived = (&ConsoleSink{Writer: &console, Color: color.Cyan}).Print
+	da.ArchiveChannel <- testOutput("1", "pond")
+	da.ArchiveChannel <- testOutput("2", "the")
+	lowScore := testOutput("3", "frog")
+	lowScore.Haikus[0].Score = &syllable.Score{Total: 0.25}
+	da.ArchiveChannel <- lowScore
+	close(da.ArchiveChannel)
+	if err = da.OutputLoop(context.Background()); err != nil {
+		t.Fatalf("Error from output loop: %v", err)
+	}
+	if filtered[BlacklistedSuffix] != 1 || filtered[BelowMinScore] != 1 {
+		t.Errorf("Expected one poem filtered for its suffix and one for its score, got %v", filtered)
+	}
+	outBytes, err := ioutil.ReadFile(filepath.Join(tmpDir, "current.json"))
+	if err != nil {
+		t.Fatalf("Error reading archive: %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(outBytes)), "\n"); len(lines) != 1 {
+		t.Errorf("Expected only the unfiltered post to be archived, got %v", lines)
+	}
+	if echoed := console.String(); !strings.Contains(echoed, "pond") || strings.Contains(echoed, "frog") {
+		t.Errorf("Expected only the archived haiku to be echoed, got %q", echoed)
+	}
+}
+
+func TestCheckWritable(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "wildhaiku-archive")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	da, err := NewDiskArchiver(&config.WildHaiku{OutputPath: tmpDir})
+	if err != nil {
+		t.Fatalf("Error creating disk archiver: %v", err)
+	}
+	if err = da.CheckWritable(); err != nil {
+		t.Errorf("Expected output directory to be writable before the output file exists, got %v", err)
+	}
+	if files, _ := ioutil.ReadDir(tmpDir); len(files) != 0 {
+		t.Errorf("Expected writable check to clean up after itself, got %v files", len(files))
+	}
+	os.RemoveAll(tmpDir)
+	if err = da.CheckWritable(); err == nil {
+		t.Errorf("Expected a missing output directory to not be writable")
+	}
+}
@@ -0,0 +1,283 @@
+/*Package bluesky contains utilities for connecting to and parsing posts from a Jetstream JSON feed of the Bluesky firehose
+ */
+package bluesky
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antipasta/wildhaiku/config"
+	"github.com/antipasta/wildhaiku/logging"
+	"github.com/antipasta/wildhaiku/source"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+)
+
+// DefaultJetstreamURL is the subscribe endpoint of a public Jetstream instance
+const DefaultJetstreamURL = "wss://jetstream2.us-east.bsky.network/subscribe"
+
+// PostCollection is the collection of Bluesky post records
+const PostCollection = "app.bsky.feed.post"
+
+// cursorSaveInterval is how often the cursor is written to CursorPath while streaming
+const cursorSaveInterval = 5 * time.Second
+
+// cursorRewind is subtracted from a saved cursor on restart, as jetstream recommends, so that events that were in flight when it was saved are replayed
+const cursorRewind = 5 * time.Second
+
+// Event is a representation of a subset of fields of a Jetstream event
+type Event struct {
+	DID    string  `json:"did"`
+	TimeUS int64   `json:"time_us"`
+	Kind   string  `json:"kind"`
+	Commit *Commit `json:"commit,omitempty"`
+}
+
+// Commit is a change to a record in a user's repository
+type Commit struct {
+	Operation  string          `json:"operation"`
+	Collection string          `json:"collection"`
+	RKey       string          `json:"rkey"`
+	CID        string          `json:"cid"`
+	Record     json.RawMessage `json:"record,omitempty"`
+}
+
+// PostRecord is a representation of a subset of fields of an app.bsky.feed.post record
+type PostRecord struct {
+	Text      string    `json:"text"`
+	Langs     []string  `json:"langs"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// URI returns the at:// URI of the record the event's commit changed
+func (e *Event) URI() string {
+	return "at://" + e.DID + "/" + e.Commit.Collection + "/" + e.Commit.RKey
+}
+
+// Permalink returns the bsky.app web URL of the post the event created
+func (e *Event) Permalink() string {
+	return "https://bsky.app/profile/" + e.DID + "/post/" + e.Commit.RKey
+}
+
+// messageReader is the part of a websocket connection that the stream is read from
+type messageReader interface {
+	ReadMessage() (int, []byte, error)
+}
+
+// Streamer is responsible for connecting to and reading posts from a Jetstream websocket feed. Implements source.Source
+type Streamer struct {
+	Config         *config.WildHaiku
+	Dialer         *websocket.Dialer
+	ProcessChannel chan *source.Post
+	// Cursor is the time_us of the last event read, sent on reconnect so that no posts are skipped
+	Cursor int64
+	// Logger is where connection errors, cursor errors and undecodable events are logged, defaults to slog.Default()
+	Logger *slog.Logger
+	// languages are the post languages to keep, from config.BlueskyLanguages
+	languages      map[string]bool
+	lastCursorSave time.Time
+	// queued are the time_us of posts sent to ProcessChannel, oldest first, that may not have been read off of it yet
+	queued []int64
+}
+
+// NewStreamer returns a bluesky.Streamer object, resuming from a few seconds before the cursor saved at config.BlueskyCursorPath if there is one
+func NewStreamer(cfg *config.WildHaiku) (*Streamer, error) {
+	languages := map[string]bool{}
+	for _, language := range cfg.BlueskyLanguages {
+		languages[language] = true
+	}
+	if len(languages) == 0 {
+		languages["en"] = true
+	}
+	bs := Streamer{
+		Config:         cfg,
+		Dialer:         websocket.DefaultDialer,
+		ProcessChannel: make(chan *source.Post, 10000),
+		Logger:         slog.Default(),
+		languages:      languages,
+	}
+	if cfg.BlueskyCursorPath != "" {
+		cursorBytes, err := ioutil.ReadFile(cfg.BlueskyCursorPath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrapf(err, "Error reading cursor from %s", cfg.BlueskyCursorPath)
+		}
+		if len(cursorBytes) > 0 {
+			bs.Cursor, err = strconv.ParseInt(strings.TrimSpace(string(cursorBytes)), 10, 64)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Error parsing cursor in %s", cfg.BlueskyCursorPath)
+			}
+			bs.Cursor -= cursorRewind.Microseconds()
+		}
+	}
+	return &bs, nil
+}
+
+// streamURL returns the Jetstream subscribe URL, filtered to post records and resuming from Cursor if set
+func (bs *Streamer) streamURL() (string, error) {
+	jetstreamURL := bs.Config.BlueskyJetstreamURL
+	if jetstreamURL == "" {
+		jetstreamURL = DefaultJetstreamURL
+	}
+	parsed, err := url.Parse(jetstreamURL)
+	if err != nil {
+		return "", errors.Wrapf(err, "Error parsing jetstream url %s", jetstreamURL)
+	}
+	query := parsed.Query()
+	query.Set("wantedCollections", PostCollection)
+	if bs.Cursor > 0 {
+		query.Set("cursor", strconv.FormatInt(bs.Cursor, 10))
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String(), nil
+}
+
+// Connect dials the Jetstream websocket and returns the connection for reading
+func (bs *Streamer) Connect() (*websocket.Conn, error) {
+	return bs.ConnectContext(context.Background())
+}
+
+// ConnectContext dials the Jetstream websocket, giving up once ctx is done, and returns the connection for reading
+func (bs *Streamer) ConnectContext(ctx context.Context) (*websocket.Conn, error) {
+	streamURL, err := bs.streamURL()
+	if err != nil {
+		return nil, err
+	}
+	conn, resp, err := bs.Dialer.DialContext(ctx, streamURL, nil)
+	if err != nil {
+		if resp != nil {
+			all, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			return nil, errors.Errorf("Received non-OK error code [%v] [%v] when connecting to jetstream: %v", resp.StatusCode, resp.Status, string(all))
+		}
+		return nil, errors.Wrapf(err, "Caught error when connecting to jetstream")
+	}
+	return conn, nil
+}
+
+// Posts returns the channel that posts are emitted on
+func (bs *Streamer) Posts() <-chan *source.Post {
+	return bs.ProcessChannel
+}
+
+// Run connects to the jetstream and reads from it until ctx is done, reconnecting from the last cursor whenever the connection fails. The cursor is saved before returning
+func (bs *Streamer) Run(ctx context.Context) error {
+	defer close(bs.ProcessChannel)
+	for ctx.Err() == nil {
+		conn, err := bs.ConnectContext(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			logging.OrDefault(bs.Logger).Warn("Error connecting to jetstream, sleeping and reconnecting", "error", err)
+			source.Sleep(ctx, 5*time.Second)
+			continue
+		}
+		stop := source.CloseOnDone(ctx, conn)
+		err = bs.StreamLoop(conn)
+		stop()
+		conn.Close()
+		if err != nil && ctx.Err() == nil {
+			logging.OrDefault(bs.Logger).Warn("Got stream error, reconnecting", "cursor", bs.Cursor, "error", err)
+		}
+		if err := bs.SaveCursor(); err != nil {
+			logging.OrDefault(bs.Logger).Error("Error saving cursor", "path", bs.Config.BlueskyCursorPath, "error", err)
+		}
+	}
+	return nil
+}
+
+// savedCursor returns the cursor to resume from after a restart: just before the oldest post still waiting on ProcessChannel, so that it is read again, or Cursor if none are waiting
+func (bs *Streamer) savedCursor() int64 {
+	if read := len(bs.queued) - len(bs.ProcessChannel); read > 0 {
+		bs.queued = bs.queued[read:]
+	}
+	if len(bs.queued) > 0 {
+		return bs.queued[0] - 1
+	}
+	return bs.Cursor
+}
+
+// SaveCursor writes the cursor of the oldest post not yet read off of ProcessChannel to config.BlueskyCursorPath, if set
+func (bs *Streamer) SaveCursor() error {
+	if bs.Config.BlueskyCursorPath == "" || bs.Cursor == 0 {
+		return nil
+	}
+	bs.lastCursorSave = time.Now()
+	tmpPath := bs.Config.BlueskyCursorPath + ".tmp"
+	err := ioutil.WriteFile(tmpPath, []byte(strconv.FormatInt(bs.savedCursor(), 10)), 0644)
+	if err != nil {
+		return errors.Wrapf(err, "Error writing cursor to %s", tmpPath)
+	}
+	return os.Rename(tmpPath, bs.Config.BlueskyCursorPath)
+}
+
+// StreamLoop reads events off of a jetstream connection, sending newly created posts to the ProcessChannel and advancing Cursor
+func (bs *Streamer) StreamLoop(conn messageReader) error {
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		post, err := bs.parseEvent(message)
+		if err != nil {
+			continue
+		}
+		if post != nil {
+			bs.ProcessChannel <- post
+			bs.queued = append(bs.queued, post.Original.(*Event).TimeUS)
+		}
+		if time.Since(bs.lastCursorSave) > cursorSaveInterval {
+			if err := bs.SaveCursor(); err != nil {
+				logging.OrDefault(bs.Logger).Error("Error saving cursor", "path", bs.Config.BlueskyCursorPath, "error", err)
+			}
+		}
+	}
+}
+
+func (bs *Streamer) parseEvent(inBytes []byte) (*source.Post, error) {
+	e := Event{}
+	err := json.Unmarshal(inBytes, &e)
+	if err != nil {
+		logging.OrDefault(bs.Logger).Warn("Error json decoding event", "event", logging.Truncate(inBytes), "length", len(inBytes), "error", err)
+		return nil, errors.Errorf("Error json decoding event [%v]: %v", logging.Truncate(inBytes), err)
+	}
+	if e.TimeUS > bs.Cursor {
+		bs.Cursor = e.TimeUS
+	}
+	if e.Kind != "commit" || e.Commit == nil || e.Commit.Operation != "create" || e.Commit.Collection != PostCollection {
+		return nil, nil
+	}
+	record := PostRecord{}
+	err = json.Unmarshal(e.Commit.Record, &record)
+	if err != nil {
+		logging.OrDefault(bs.Logger).Warn("Error json decoding post record", "record", logging.Truncate(e.Commit.Record), "length", len(e.Commit.Record), "error", err)
+		return nil, errors.Errorf("Error json decoding post record [%v]: %v", logging.Truncate(e.Commit.Record), err)
+	}
+	language := ""
+	for _, lang := range record.Langs {
+		// tags like en-US are matched on their primary language
+		if bs.languages[lang] || bs.languages[strings.SplitN(lang, "-", 2)[0]] {
+			language = lang
+			break
+		}
+	}
+	if language == "" || strings.TrimSpace(record.Text) == "" {
+		return nil, nil
+	}
+	return &source.Post{
+		ID:        e.URI(),
+		Author:    e.DID,
+		Permalink: e.Permalink(),
+		Text:      record.Text,
+		Language:  language,
+		Timestamp: record.CreatedAt,
+		Original:  &e,
+	}, nil
+}
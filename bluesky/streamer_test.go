@@ -0,0 +1,165 @@
+package bluesky
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/antipasta/wildhaiku/config"
+	"github.com/gorilla/websocket"
+)
+
+var sampleEvents = []string{
+	`{"did":"did:plc:poet","time_us":1725911162000000,"kind":"commit","commit":{"operation":"create","collection":"app.bsky.feed.post","rkey":"3kpond","cid":"c1","record":{"$type":"app.bsky.feed.post","text":"an old silent pond","langs":["en"],"createdAt":"2024-09-09T19:46:02.102Z"}}}`,
+	`{"did":"did:plc:poet","time_us":1725911162000001,"kind":"commit","commit":{"operation":"create","collection":"app.bsky.feed.post","rkey":"3kteich","record":{"text":"ein alter teich","langs":["de"]}}}`,
+	`{"did":"did:plc:poet","time_us":1725911162000002,"kind":"identity","identity":{"did":"did:plc:poet"}}`,
+	`{"did":"did:plc:poet","time_us":1725911162000003,"kind":"commit","commit":{"operation":"delete","collection":"app.bsky.feed.post","rkey":"3kpond"}}`,
+	`{"did":"did:plc:frog","time_us":1725911162000004,"kind":"commit","commit":{"operation":"create","collection":"app.bsky.feed.post","rkey":"3kfrog","record":{"text":"a frog jumps into the pond","langs":["en-US"]}}}`,
+	`not json`,
+}
+
+// fakeJetstream replays sampleEvents newer than the requested cursor over a websocket, dropping the first connection after dropAfter events.
+// Requested cursors are sent on cursors
+func fakeJetstream(t *testing.T, cursors chan string, dropAfter int) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	connections := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("wantedCollections") != PostCollection {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		cursors <- r.URL.Query().Get("cursor")
+		cursor, _ := strconv.ParseInt(r.URL.Query().Get("cursor"), 10, 64)
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Error upgrading connection: %v", err)
+			return
+		}
+		defer conn.Close()
+		connections++
+		for i, event := range sampleEvents {
+			if connections == 1 && i == dropAfter {
+				break
+			}
+			e := Event{}
+			if json.Unmarshal([]byte(event), &e) == nil && e.TimeUS <= cursor {
+				continue
+			}
+			conn.WriteMessage(websocket.TextMessage, []byte(event))
+		}
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	}))
+}
+
+func TestStreamer(t *testing.T) {
+	cursors := make(chan string, 10)
+	server := fakeJetstream(t, cursors, 3)
+	defer server.Close()
+	tmpDir, err := ioutil.TempDir("", "wildhaiku-bluesky")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	cfg := config.WildHaiku{
+		BlueskyJetstreamURL: "ws" + strings.TrimPrefix(server.URL, "http") + "/subscribe",
+		BlueskyCursorPath:   filepath.Join(tmpDir, "cursor"),
+	}
+
+	s, err := NewStreamer(&cfg)
+	if err != nil {
+		t.Fatalf("Error creating streamer: %v", err)
+	}
+	conn, err := s.Connect()
+	if err != nil {
+		t.Fatalf("Error connecting to fake jetstream: %v", err)
+	}
+	err = s.StreamLoop(conn)
+	conn.Close()
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("Expected stream to end with a normal close, got %v", err)
+	}
+	if cursor := <-cursors; cursor != "" {
+		t.Errorf("Expected first connection to have no cursor, got %v", cursor)
+	}
+	if len(s.ProcessChannel) != 1 {
+		t.Fatalf("Expected 1 post in channel before the connection dropped, got %v", len(s.ProcessChannel))
+	}
+	if s.Cursor != 1725911162000002 {
+		t.Errorf("Expected cursor to advance to 1725911162000002, got %v", s.Cursor)
+	}
+
+	// the post is still queued, so the saved cursor must come before it
+	err = s.SaveCursor()
+	if err != nil {
+		t.Fatalf("Error saving cursor: %v", err)
+	}
+	cursorBytes, err := ioutil.ReadFile(cfg.BlueskyCursorPath)
+	if err != nil {
+		t.Fatalf("Error reading saved cursor: %v", err)
+	}
+	if string(cursorBytes) != "1725911161999999" {
+		t.Errorf("Expected saved cursor to be just before the queued post, got %s", cursorBytes)
+	}
+	post := <-s.ProcessChannel
+	if post.Text != "an old silent pond" || post.Language != "en" || post.Author != "did:plc:poet" {
+		t.Errorf("Unexpected post fields %+v", post)
+	}
+	if post.ID != "at://did:plc:poet/app.bsky.feed.post/3kpond" {
+		t.Errorf("Expected at uri as post id, got %v", post.ID)
+	}
+	if post.Permalink != "https://bsky.app/profile/did:plc:poet/post/3kpond" {
+		t.Errorf("Expected web permalink, got %v", post.Permalink)
+	}
+	if post.Timestamp.Year() != 2024 {
+		t.Errorf("Expected timestamp from createdAt, got %v", post.Timestamp)
+	}
+	if s.savedCursor() != s.Cursor {
+		t.Errorf("Expected saved cursor to catch up with cursor once the queue is read, got %v", s.savedCursor())
+	}
+
+	restarted, err := NewStreamer(&cfg)
+	if err != nil {
+		t.Fatalf("Error creating restarted streamer: %v", err)
+	}
+	if restarted.Cursor != 1725911156999999 {
+		t.Errorf("Expected restarted streamer to resume a few seconds before the saved cursor, got %v", restarted.Cursor)
+	}
+	conn, err = restarted.Connect()
+	if err != nil {
+		t.Fatalf("Error reconnecting to fake jetstream: %v", err)
+	}
+	restarted.StreamLoop(conn)
+	conn.Close()
+	if cursor := <-cursors; cursor != "1725911156999999" {
+		t.Errorf("Expected reconnect to send the rewound cursor, got %v", cursor)
+	}
+	if len(restarted.ProcessChannel) != 2 {
+		t.Fatalf("Expected the queued post to be replayed along with the rest on reconnect, got %v", len(restarted.ProcessChannel))
+	}
+	if post = <-restarted.ProcessChannel; post.Text != "an old silent pond" {
+		t.Errorf("Expected the post queued at shutdown first, got %+v", post)
+	}
+	post = <-restarted.ProcessChannel
+	if post.Text != "a frog jumps into the pond" || post.Language != "en-US" {
+		t.Errorf("Expected regional language tag to match, got %+v", post)
+	}
+	if restarted.Cursor != 1725911162000004 {
+		t.Errorf("Expected cursor to advance to 1725911162000004, got %v", restarted.Cursor)
+	}
+
+	logged := bytes.Buffer{}
+	restarted.Logger = slog.New(slog.NewTextHandler(&logged, nil))
+	badRecord := `{"did":"did:plc:poet","kind":"commit","commit":{"operation":"create","collection":"app.bsky.feed.post","rkey":"3kbad","record":"` + strings.Repeat("pond ", 1000) + `"}}`
+	_, err = restarted.parseEvent([]byte(badRecord))
+	if err == nil || len(err.Error()) > 1000 || logged.Len() == 0 || logged.Len() > 1000 {
+		t.Errorf("Expected undecodable post record to be logged and returned truncated, got error %v and log %v", err, logged.String())
+	}
+}
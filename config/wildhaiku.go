@@ -7,16 +7,87 @@ import (
 	"io/ioutil"
 )
 
+// Form describes a poem form to search tweets for. Lines may be left empty for the built in haiku, tanka and cinquain forms
+type Form struct {
+	Name  string
+	Lines []int
+	// NoEstimatedWords rejects poems of this form containing words whose syllable counts were estimated
+	NoEstimatedWords bool
+}
+
 // WildHaiku holds all configuration needed to run the WildHaiku daemon
 type WildHaiku struct {
-	ConsumerKey        string
-	ConsumerSecret     string
-	AccessToken        string
-	AccessSecret       string
-	TrackingKeywords   []string
-	CorpusPath         string
-	OutputPath         string
+	ConsumerKey      string
+	ConsumerSecret   string
+	AccessToken      string
+	AccessSecret     string
+	BearerToken      string
+	TrackingKeywords []string
+	CorpusPath       string
+	OutputPath       string
+	// ProcessWorkerCount is how many workers process posts at once, defaults to GOMAXPROCS when 0
 	ProcessWorkerCount int
+	// OverlayPaths are dictionaries in cmu or simple "word count" format merged on top of CorpusPath, later paths taking precedence
+	OverlayPaths []string
+	// EstimateUnknownWords enables rule based syllable estimation for words not found in the cmu corpus
+	EstimateUnknownWords bool
+	// SplitHashtags enables counting syllables of #hashtags and @mentions by splitting them into their component words.
+	// It also decides whether @mentions in the middle of a post are searched: they are kept to be read as words when it is set, and removed otherwise. Leading reply mentions are always removed
+	SplitHashtags bool
+	// Forms are the poem forms to search each tweet for, defaults to haiku only
+	Forms []Form
+	// SearchMode chooses which words are tried as the start of a poem: sentence(default), clause or word
+	SearchMode string
+	// MinScore drops poems whose quality score total is below it before archiving, 0 keeps everything
+	MinScore float64
+	// SortByScore archives the poems of each tweet best score first
+	SortByScore bool
+	// LineBreakRules enables part of speech tagging, and sets for each line position whether a line ending on a closed class word or splitting a compound noun is allowed, penalized or rejected. The final rule applies to any further lines
+	LineBreakRules []string
+	// Source selects where posts are read from: twitter(default), mastodon, bluesky or replay
+	Source string
+	// MastodonInstance is the base URL of the instance whose public stream is read, ie https://mastodon.social
+	MastodonInstance string
+	// MastodonAccessToken is sent as a bearer token, for instances that require authentication to stream
+	MastodonAccessToken string
+	// MastodonLanguages are the status languages to search, defaults to en
+	MastodonLanguages []string
+	// BlueskyJetstreamURL is the jetstream subscribe endpoint that bluesky posts are read from, defaults to a public instance
+	BlueskyJetstreamURL string
+	// BlueskyLanguages are the post languages to search, defaults to en
+	BlueskyLanguages []string
+	// BlueskyCursorPath is a file the jetstream cursor is saved to, so that a restart resumes where it left off
+	BlueskyCursorPath string
+	// ReplayPaths are files, globs or - for stdin, read in order by the replay source. Files ending in .gz are decompressed
+	ReplayPaths []string
+	// ReplayFormat is how replayed lines are read: tweets(default), one v1.1 stream tweet json per line, tweets_v2, one v2 stream tweet json per line, or text, one post per line
+	ReplayFormat string
+	// ReplayRate paces replayed tweets as a multiplier of the speed they were streamed at, 0 replays as fast as possible
+	ReplayRate float64
+	// TruncatedTweets sets how posts cut short by their source are processed: skip(default) ignores them, confine only searches the sentences that end before the cut
+	TruncatedTweets string
+	// LookupTruncatedTweets fetches the full text of truncated tweets from the API as they are streamed, but not as they are replayed
+	LookupTruncatedTweets bool
+	// ShutdownTimeoutSeconds is how long posts already read are given to be processed and archived after SIGINT or SIGTERM, defaults to 30
+	ShutdownTimeoutSeconds int
+	// ProcessStatsSeconds logs the queue depth and per worker throughput of the ProcessWorkerCount workers every this many seconds, 0 disables it
+	ProcessStatsSeconds int
+	// MetricsAddress is the address prometheus metrics are served on at /metrics, ie :9100. Metrics are not served if empty
+	MetricsAddress string
+	// HealthAddress is the address the /healthz and /readyz checks are served on, and may be the same as MetricsAddress. Checks are not served if empty
+	HealthAddress string
+	// HealthStallSeconds is how long the stream can go without sending a tweet or keepalive before /healthz fails, defaults to 300
+	HealthStallSeconds int
+	// HealthMaxBacklog is the share of a pipeline channel's capacity that can be waiting to be processed or archived before /readyz fails, defaults to 0.9
+	HealthMaxBacklog float64
+	// LogFormat is how log records are written: logfmt(default) or json
+	LogFormat string
+	// LogLevel is the lowest level logged: debug, info(default), warn or error
+	LogLevel string
+	// LogLevels overrides LogLevel for a component: twitter, mastodon, bluesky, replay, haiku or archive
+	LogLevels map[string]string
+	// NoEchoHaikus stops each archived haiku being printed to the console in color, separately from the log. Haikus are echoed by default
+	NoEchoHaikus bool
 }
 
 // Load takes the path to the wildhaiku config, and returns an instance of a *WildHaiku config. Errors if file cannot be read or does not parse correctly
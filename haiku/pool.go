@@ -0,0 +1,153 @@
+package haiku
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/antipasta/wildhaiku/logging"
+)
+
+// workerCounters are the running totals of one worker, updated atomically as it processes posts
+type workerCounters struct {
+	processed uint64
+	found     uint64
+	busyNanos uint64
+}
+
+// add counts a post that took busy to process, and whether any poems were found in it
+func (wc *workerCounters) add(busy time.Duration, found bool) {
+	atomic.AddUint64(&wc.processed, 1)
+	if found {
+		atomic.AddUint64(&wc.found, 1)
+	}
+	atomic.AddUint64(&wc.busyNanos, uint64(busy))
+}
+
+// WorkerStats counts the posts one worker of a Pool has processed
+type WorkerStats struct {
+	Processed uint64
+	// Found is the number of processed posts that contained a poem
+	Found uint64
+	// Busy is the time spent processing posts, not counting time waiting on the input and output channels
+	Busy time.Duration
+}
+
+// PoolStats is a point in time view of a Pool's input queue and workers
+type PoolStats struct {
+	// QueueDepth is the number of posts waiting to be processed, out of QueueCapacity
+	QueueDepth    int
+	QueueCapacity int
+	// Elapsed is the time since the pool was created
+	Elapsed time.Duration
+	Workers []WorkerStats
+}
+
+// Throughput returns the posts per second processed by worker i since the pool was created
+func (ps PoolStats) Throughput(i int) float64 {
+	if ps.Elapsed <= 0 {
+		return 0
+	}
+	return float64(ps.Workers[i].Processed) / ps.Elapsed.Seconds()
+}
+
+// Utilization returns the share of time since the pool was created that worker i spent processing posts, between 0 and 1
+func (ps PoolStats) Utilization(i int) float64 {
+	if ps.Elapsed <= 0 {
+		return 0
+	}
+	return ps.Workers[i].Busy.Seconds() / ps.Elapsed.Seconds()
+}
+
+// String summarizes the queue and each worker on one line
+func (ps PoolStats) String() string {
+	workers := make([]string, len(ps.Workers))
+	for i, worker := range ps.Workers {
+		workers[i] = fmt.Sprintf("%d: %d posts %.1f/s %.0f%% busy", i, worker.Processed, ps.Throughput(i), 100*ps.Utilization(i))
+	}
+	return fmt.Sprintf("queue %d/%d, workers [%s]", ps.QueueDepth, ps.QueueCapacity, strings.Join(workers, ", "))
+}
+
+// Pool runs a Processor's ProcessLoop on a fixed number of workers, keeping WorkerStats for each
+type Pool struct {
+	processor *Processor
+	counters  []workerCounters
+	created   time.Time
+}
+
+// NewPool creates a Pool of workers for processor. If workers is not positive, the pool has one worker per GOMAXPROCS, as processing is CPU bound
+func NewPool(processor *Processor, workers int) *Pool {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	return &Pool{processor: processor, counters: make([]workerCounters, workers), created: time.Now()}
+}
+
+// Size returns the number of workers in the pool
+func (pool *Pool) Size() int {
+	return len(pool.counters)
+}
+
+// Run runs every worker until the input channel is closed and drained, or ctx is done. Returns the first error from a worker, once all of them have returned
+func (pool *Pool) Run(ctx context.Context) error {
+	errs := make(chan error, len(pool.counters))
+	for i := range pool.counters {
+		go func(counters *workerCounters) {
+			errs <- pool.processor.processLoop(ctx, counters)
+		}(&pool.counters[i])
+	}
+	var firstErr error
+	for range pool.counters {
+		if err := <-errs; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Stats returns the current queue depth and the totals of each worker. Safe to call while the pool is running
+func (pool *Pool) Stats() PoolStats {
+	stats := PoolStats{
+		QueueDepth:    len(pool.processor.inputChannel),
+		QueueCapacity: cap(pool.processor.inputChannel),
+		Elapsed:       time.Since(pool.created),
+		Workers:       make([]WorkerStats, len(pool.counters)),
+	}
+	for i := range pool.counters {
+		counters := &pool.counters[i]
+		stats.Workers[i] = WorkerStats{
+			Processed: atomic.LoadUint64(&counters.processed),
+			Found:     atomic.LoadUint64(&counters.found),
+			Busy:      time.Duration(atomic.LoadUint64(&counters.busyNanos)),
+		}
+	}
+	return stats
+}
+
+// LogStats logs the pool's queue depth and total throughput every interval until ctx is done, to the Processor's Logger.
+// The throughput of each worker is logged at debug level
+func (pool *Pool) LogStats(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	logger := logging.OrDefault(pool.processor.Logger)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			stats := pool.Stats()
+			processed, throughput := uint64(0), 0.0
+			for i, worker := range stats.Workers {
+				processed += worker.Processed
+				throughput += stats.Throughput(i)
+				logger.Debug("Process worker", "worker", i, "processed", worker.Processed, "found", worker.Found,
+					"throughput", stats.Throughput(i), "utilization", stats.Utilization(i))
+			}
+			logger.Info("Process pool", "queue_depth", stats.QueueDepth, "queue_capacity", stats.QueueCapacity,
+				"workers", len(stats.Workers), "processed", processed, "throughput", throughput)
+		}
+	}
+}
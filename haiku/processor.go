@@ -1,62 +1,315 @@
-/*Package haiku is used for processing Tweets and parsing out found Haikus
+/*Package haiku is used for processing Posts and parsing out found Haikus, and poems of any other configured forms
  */
 package haiku
 
 import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
 	"github.com/antipasta/wildhaiku/config"
+	"github.com/antipasta/wildhaiku/source"
 	"github.com/antipasta/wildhaiku/syllable"
-	"github.com/antipasta/wildhaiku/twitter"
 	"github.com/pkg/errors"
 )
 
-// Output is a Tweet bundled with all found haikus from that tweet, used for outputting to file
+// Output is a Post bundled with all found haikus(and poems of other forms, labelled by form) from that post, used for outputting to file.
+// The byte offsets of each poem line are relative to Post.Text
 type Output struct {
-	Haikus []syllable.Haiku
-	Tweet  *twitter.Tweet
+	Haikus []syllable.Poem
+	Post   *source.Post
 }
 
-// Processor reads in tweets on a channel, and outputs them to an output channel
+// ProcessResult is the outcome of processing a post, see Processor.OnProcessed
+type ProcessResult string
+
+const (
+	// PoemsFound means at least one poem was found in the post
+	PoemsFound ProcessResult = "found"
+	// NoPoems means the post was searched, but no poems were found
+	NoPoems ProcessResult = "none"
+	// UnknownWords means the post was not searched, as NewParagraph rejected it for having too few syllables around words not in the corpus
+	UnknownWords ProcessResult = "unknown_words"
+	// TruncatedSkipped means the post was not searched, as it was truncated
+	TruncatedSkipped ProcessResult = "truncated"
+)
+
+// ProcessedFunc is called after every post is processed, with its result, how long processing took and how many poems were found
+type ProcessedFunc func(result ProcessResult, elapsed time.Duration, poems int)
+
+// Processor reads in posts on a channel, and outputs them to an output channel. A Processor is safe for concurrent use, so any number of workers can run its ProcessLoop
 type Processor struct {
-	inputChannel  <-chan *twitter.Tweet
+	inputChannel  <-chan *source.Post
 	outputChannel chan<- *Output
 	Config        *config.WildHaiku
-	corpus        *syllable.CMUCorpus
+	forms         []syllable.Form
+	searchMode    syllable.SearchMode
+	scorer        *syllable.Scorer
+	cleaner       *syllable.TextCleaner
+	// confineTruncated searches truncated posts up to their last complete sentence, instead of skipping them
+	confineTruncated bool
+	// OnProcessed, if set, is called by every worker after each post it processes. Must be set before any worker starts
+	OnProcessed ProcessedFunc
+	// Logger is where worker pool stats are logged, defaults to slog.Default()
+	Logger *slog.Logger
+	// corpus holds the *syllable.CorpusSnapshot posts are processed with, see SwapCorpus
+	corpus atomic.Value
 }
 
-// NewProcessor creates a new instance of the processor class, using specified input and output channels
-func NewProcessor(cfg *config.WildHaiku, tweetIn <-chan *twitter.Tweet, processedOut chan<- *Output) (*Processor, error) {
-	cmu, err := syllable.NewCMUCorpus(cfg.CorpusPath)
+// NewCorpus loads the CMU corpus and any overlay dictionaries specified in config, enables the optional syllable counting features turned on in config,
+// and returns a read only snapshot of it that can be shared by every worker. Its MinSyllables is the size of the smallest of forms, the forms searched for with it
+func NewCorpus(cfg *config.WildHaiku, forms []syllable.Form) (*syllable.CorpusSnapshot, error) {
+	cmu, err := syllable.NewCMUCorpus(cfg.CorpusPath, cfg.OverlayPaths...)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Error loading CMU corpus from %v", cfg.CorpusPath)
 	}
-	return &Processor{
-		corpus:        cmu,
-		inputChannel:  tweetIn,
-		outputChannel: processedOut,
-	}, nil
+	if cfg.EstimateUnknownWords {
+		cmu.Fallback = syllable.EstimateSyllables
+	}
+	if cfg.SplitHashtags {
+		cmu.CorpusExpand = append(cmu.CorpusExpand, (*syllable.CMUCorpus).ExpandHashtag)
+	}
+	// line breaks are checked using part of speech tags
+	cmu.Tagging = len(cfg.LineBreakRules) > 0
+	for i, form := range forms {
+		if i == 0 || form.TotalSyllables() < cmu.MinSyllables {
+			cmu.MinSyllables = form.TotalSyllables()
+		}
+	}
+	return cmu.Snapshot(), nil
+}
+
+// NewForms converts the forms specified in config to syllable.Forms, defaulting to haiku if none are specified. Errors if a form has no lines and is not a built in form, or has a line without syllables
+func NewForms(cfg *config.WildHaiku) ([]syllable.Form, error) {
+	if len(cfg.Forms) == 0 {
+		return []syllable.Form{syllable.HaikuForm}, nil
+	}
+	forms := []syllable.Form{}
+	for _, formCfg := range cfg.Forms {
+		form := syllable.Form{Name: formCfg.Name, Lines: formCfg.Lines}
+		if len(form.Lines) == 0 {
+			builtin, exists := syllable.BuiltinForm(formCfg.Name)
+			if !exists {
+				return nil, errors.Errorf("Form %v has no lines and is not a built in form", formCfg.Name)
+			}
+			form = builtin
+		}
+		for _, syllables := range form.Lines {
+			if syllables <= 0 {
+				return nil, errors.Errorf("Form %v has a line of %d syllables, every line must have at least one", form.Name, syllables)
+			}
+		}
+		if formCfg.NoEstimatedWords {
+			form.Constraints = append(form.Constraints, syllable.NoEstimatedWords)
+		}
+		forms = append(forms, form)
+	}
+	return forms, nil
+}
+
+// NewProcessor creates a new instance of the processor class, using specified input and output channels
+func NewProcessor(cfg *config.WildHaiku, postIn <-chan *source.Post, processedOut chan<- *Output) (*Processor, error) {
+	forms, err := NewForms(cfg)
+	if err != nil {
+		return nil, err
+	}
+	cmu, err := NewCorpus(cfg, forms)
+	if err != nil {
+		return nil, err
+	}
+	searchMode, err := syllable.ParseSearchMode(cfg.SearchMode)
+	if err != nil {
+		return nil, err
+	}
+	confineTruncated := false
+	switch cfg.TruncatedTweets {
+	case "", "skip":
+	case "confine":
+		confineTruncated = true
+	default:
+		return nil, errors.Errorf("Unknown TruncatedTweets setting %v", cfg.TruncatedTweets)
+	}
+	scorer := syllable.NewScorer()
+	if len(cfg.LineBreakRules) > 0 {
+		checker := &syllable.LineBreakChecker{}
+		for _, ruleName := range cfg.LineBreakRules {
+			rule, err := syllable.ParseLineBreakRule(ruleName)
+			if err != nil {
+				return nil, err
+			}
+			checker.Rules = append(checker.Rules, rule)
+		}
+		scorer.LineBreakChecker = checker
+		for i := range forms {
+			forms[i].Constraints = append(forms[i].Constraints, checker.Accepts)
+		}
+	}
+	p := &Processor{
+		forms:            forms,
+		searchMode:       searchMode,
+		scorer:           scorer,
+		cleaner:          &syllable.TextCleaner{KeepMentions: cfg.SplitHashtags},
+		confineTruncated: confineTruncated,
+		inputChannel:     postIn,
+		outputChannel:    processedOut,
+		Logger:           slog.Default(),
+	}
+	p.SwapCorpus(cmu)
+	return p, nil
+}
+
+// Corpus returns the corpus snapshot posts are currently processed with
+func (p *Processor) Corpus() *syllable.CorpusSnapshot {
+	corpus, _ := p.corpus.Load().(*syllable.CorpusSnapshot)
+	return corpus
+}
+
+// SwapCorpus atomically replaces the corpus snapshot posts are processed with, returning the previous one. Posts already being processed finish with the snapshot they started with
+func (p *Processor) SwapCorpus(corpus *syllable.CorpusSnapshot) *syllable.CorpusSnapshot {
+	previous, _ := p.corpus.Swap(corpus).(*syllable.CorpusSnapshot)
+	return previous
+}
+
+// ReloadCorpus reloads the CMU corpus and overlay dictionaries specified in config for the processor's forms, and swaps them in for posts processed from then on.
+// Errors and keeps the current corpus if they could not be loaded
+func (p *Processor) ReloadCorpus(cfg *config.WildHaiku) error {
+	corpus, err := NewCorpus(cfg, p.forms)
+	if err != nil {
+		return errors.Wrap(err, "Error reloading corpus")
+	}
+	p.SwapCorpus(corpus)
+	return nil
+}
+
+// ProcessLoop reads in Posts on input channel, and if any poems are found,outputs an Output object  on the output channel.
+// Returns nil once the input channel is closed and drained, or ctx.Err() if ctx is done first
+func (p *Processor) ProcessLoop(ctx context.Context) error {
+	return p.processLoop(ctx, &workerCounters{})
 }
 
-// ProcessLoop reads in Tweets on input channel, and if any haikus are found,outputs an Output object  on the output channel
-func (p *Processor) ProcessLoop() error {
-	for tweet := range p.inputChannel {
-		output := p.process(tweet)
+// processLoop is ProcessLoop, counting the posts it processes in counters
+func (p *Processor) processLoop(ctx context.Context, counters *workerCounters) error {
+	for {
+		var post *source.Post
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case next, ok := <-p.inputChannel:
+			if !ok {
+				return nil
+			}
+			post = next
+		}
+		started := time.Now()
+		output, result := p.processPost(post)
+		elapsed := time.Since(started)
+		counters.add(elapsed, result == PoemsFound)
+		if p.OnProcessed != nil {
+			poems := 0
+			if output != nil {
+				poems = len(output.Haikus)
+			}
+			p.OnProcessed(result, elapsed, poems)
+		}
 		if output == nil {
 			// Could not find haiku
 			continue
 		}
 		if len(output.Haikus) > 0 {
-			p.outputChannel <- output
+			select {
+			case p.outputChannel <- output:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
 		}
+	}
+}
 
+// completeSentences returns text up to the end of its last complete sentence, dropping a sentence cut off by truncation. Empty if text has no complete sentence
+func completeSentences(text string) string {
+	end := 0
+	for i, char := range text {
+		if char != '.' && char != '!' && char != '?' {
+			continue
+		}
+		next := i + 1
+		for next < len(text) {
+			// closing quotes and brackets belong to the sentence
+			closing, size := utf8.DecodeRuneInString(text[next:])
+			if !strings.ContainsRune(`"')]”’`, closing) {
+				break
+			}
+			next += size
+		}
+		if following, _ := utf8.DecodeRuneInString(text[next:]); next == len(text) || unicode.IsSpace(following) {
+			end = next
+		}
 	}
-	return nil
+	return text[:end]
+}
+
+func (p *Processor) process(post *source.Post) *Output {
+	output, _ := p.processPost(post)
+	return output
 }
 
-func (p *Processor) process(t *twitter.Tweet) *Output {
-	paragraph, err := p.corpus.NewParagraph(t.FullText())
+// processPost searches a post for poems, returning nil if it could not be searched along with why
+func (p *Processor) processPost(post *source.Post) (*Output, ProcessResult) {
+	text := post.Text
+	if post.Truncated {
+		if !p.confineTruncated {
+			return nil, TruncatedSkipped
+		}
+		// confined text is a prefix of the post, so offsets into it are offsets into the post
+		text = completeSentences(text)
+		if text == "" {
+			return nil, TruncatedSkipped
+		}
+	}
+	var cleaned *syllable.CleanedText
+	if p.cleaner != nil {
+		removed := make([]syllable.Span, len(post.Removed))
+		for i, span := range post.Removed {
+			removed[i] = syllable.Span(span)
+		}
+		cleanedText := p.cleaner.Clean(text, removed...)
+		cleaned = &cleanedText
+		text = cleaned.Text
+	}
+	corpus := p.Corpus()
+	if corpus == nil {
+		return nil, NoPoems
+	}
+	paragraph, err := corpus.NewParagraph(text)
 	if err != nil {
-		return nil
+		return nil, UnknownWords
+	}
+	forms := p.forms
+	if len(forms) == 0 {
+		forms = []syllable.Form{syllable.HaikuForm}
+	}
+	foundPoems := []syllable.Poem{}
+	for _, form := range forms {
+		foundPoems = append(foundPoems, paragraph.FindForm(form, p.searchMode)...)
+	}
+	if p.scorer != nil {
+		for i := range foundPoems {
+			// scored against the uncleaned text, so that links and emoji count against it
+			score := p.scorer.Score(foundPoems[i], paragraph, post.Text)
+			foundPoems[i].Score = &score
+		}
+	}
+	if cleaned != nil {
+		for i := range foundPoems {
+			foundPoems[i] = cleaned.RestoreOffsets(foundPoems[i])
+		}
+	}
+	if len(foundPoems) == 0 {
+		return &Output{Post: post, Haikus: foundPoems}, NoPoems
 	}
-	foundHaikus := paragraph.Subdivide(5, 7, 5)
-	return &Output{Tweet: t, Haikus: foundHaikus}
+	return &Output{Post: post, Haikus: foundPoems}, PoemsFound
 }
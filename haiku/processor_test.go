@@ -1,10 +1,17 @@
 package haiku
 
 import (
+	"context"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/antipasta/wildhaiku/config"
+	"github.com/antipasta/wildhaiku/source"
 	"github.com/antipasta/wildhaiku/syllable"
-	"github.com/antipasta/wildhaiku/twitter"
 )
 
 func TestProcess(t *testing.T) {
@@ -12,8 +19,9 @@ func TestProcess(t *testing.T) {
 	if err != nil {
 		t.Errorf("Error loading cmu dictionary %+v", err)
 	}
-	p := &Processor{corpus: cmu}
-	tweet := twitter.Tweet{Text: "no haikus here"}
+	p := &Processor{}
+	p.SwapCorpus(cmu.Snapshot())
+	tweet := source.Post{Text: "no haikus here"}
 	output := p.process(&tweet)
 	if output == nil {
 		t.Errorf("Expected to get a value back")
@@ -21,7 +29,7 @@ func TestProcess(t *testing.T) {
 	if len(output.Haikus) > 0 {
 		t.Errorf("Expected to find no haikus for tweet %+v, got %+v", tweet, output.Haikus)
 	}
-	haikuTweet := twitter.Tweet{Text: "this is a haiku. hope the test finds it alright, i think that it should."}
+	haikuTweet := source.Post{Text: "this is a haiku. hope the test finds it alright, i think that it should."}
 	output = p.process(&haikuTweet)
 	if output == nil {
 		t.Errorf("Expected to get a value back")
@@ -34,3 +42,235 @@ func TestProcess(t *testing.T) {
 		t.Errorf("Haikus %+v did not match expected %+v", output.Haikus[0], expected)
 	}
 }
+
+func TestProcessForms(t *testing.T) {
+	cmu, err := syllable.NewCMUCorpus("../syllable/cmudict.dict")
+	if err != nil {
+		t.Errorf("Error loading cmu dictionary %+v", err)
+	}
+	forms, err := NewForms(&config.WildHaiku{Forms: []config.Form{{Name: "haiku"}, {Name: "tanka"}, {Name: "twoliner", Lines: []int{3, 3}}}})
+	if err != nil {
+		t.Fatalf("Error creating forms %v", err)
+	}
+	p := &Processor{forms: forms}
+	p.SwapCorpus(cmu.Snapshot())
+	tanka := source.Post{Text: "this is a haiku. hope the test finds it alright, i think that it should. it could be longer as well, with two more lines at the end."}
+	output := p.process(&tanka)
+	foundForms := map[string]int{}
+	for _, poem := range output.Haikus {
+		foundForms[poem.Form]++
+	}
+	if foundForms["haiku"] == 0 || foundForms["tanka"] != 1 || foundForms["twoliner"] == 0 {
+		t.Errorf("Expected to find haiku, tanka and twoliner forms, got %+v", foundForms)
+	}
+	for _, poem := range output.Haikus {
+		if poem.Form == "tanka" && len(poem.Lines) != 5 {
+			t.Errorf("Expected tanka to have 5 lines, got %+v", poem.ToStringSlice())
+		}
+	}
+
+	corpus, err := NewCorpus(&config.WildHaiku{CorpusPath: "../syllable/cmudict.dict"}, forms)
+	if err != nil || corpus.MinSyllables() != 6 {
+		t.Errorf("Expected the corpus to keep paragraphs as short as the twoliner form, got %v %v", corpus, err)
+	}
+	if _, err := NewForms(&config.WildHaiku{Forms: []config.Form{{Name: "sonnet"}}}); err == nil {
+		t.Errorf("Should get an error for an unknown form with no lines")
+	}
+	for _, lines := range [][]int{{5, 7, 0}, {5, -7, 5}} {
+		if _, err := NewForms(&config.WildHaiku{Forms: []config.Form{{Name: "broken", Lines: lines}}}); err == nil {
+			t.Errorf("Should get an error for a form with lines %v", lines)
+		}
+	}
+}
+
+func TestProcessCleaned(t *testing.T) {
+	cmu, err := syllable.NewCMUCorpus("../syllable/cmudict.dict")
+	if err != nil {
+		t.Errorf("Error loading cmu dictionary %+v", err)
+	}
+	p := &Processor{cleaner: &syllable.TextCleaner{}}
+	p.SwapCorpus(cmu.Snapshot())
+	text := "@bob @carol this is a haiku 🎉 https://t.co/abc hope the test finds it alright, i think that it should"
+	output := p.process(&source.Post{Text: text})
+	if len(output.Haikus) != 1 {
+		t.Fatalf("Expected to find 1 haiku in text with mentions, emoji and links, got %+v", output.Haikus)
+	}
+	expected := [3]string{"this is a haiku", "hope the test finds it alright,", "i think that it should"}
+	if output.Haikus[0].ToStringArray() != expected {
+		t.Errorf("Haiku %+v did not match expected %+v", output.Haikus[0].ToStringArray(), expected)
+	}
+	offsets := output.Haikus[0].Offsets()
+	for i, line := range expected {
+		if text[offsets[i][0]:offsets[i][1]] != line {
+			t.Errorf("Expected line %d offsets %v to point at %q in the original text, got %q", i, offsets[i], line, text[offsets[i][0]:offsets[i][1]])
+		}
+	}
+
+	// spans marked by the source are removed too, ie a link the cleaner would not recognise
+	text = "this is a haiku pic.twitter.com/frog hope the test finds it alright, i think that it should"
+	start := strings.Index(text, "pic.")
+	output = p.process(&source.Post{Text: text, Removed: []source.Span{{Start: start, End: start + len("pic.twitter.com/frog")}}})
+	if len(output.Haikus) != 1 || output.Haikus[0].ToStringArray() != expected {
+		t.Fatalf("Expected to find 1 haiku once removed spans are cleaned, got %+v", output.Haikus)
+	}
+	offsets = output.Haikus[0].Offsets()
+	if text[offsets[1][0]:offsets[1][1]] != expected[1] {
+		t.Errorf("Expected offsets to point into the post text, got %q", text[offsets[1][0]:offsets[1][1]])
+	}
+}
+
+func TestProcessTruncated(t *testing.T) {
+	cmu, err := syllable.NewCMUCorpus("../syllable/cmudict.dict")
+	if err != nil {
+		t.Errorf("Error loading cmu dictionary %+v", err)
+	}
+	text := "this is a haiku. hope the test finds it alright, i think that it should. and then there is mo"
+	p := &Processor{}
+	p.SwapCorpus(cmu.Snapshot())
+	if output := p.process(&source.Post{Text: text, Truncated: true}); output != nil {
+		t.Errorf("Expected truncated post to be skipped, got %+v", output)
+	}
+	p.confineTruncated = true
+	output := p.process(&source.Post{Text: text, Truncated: true})
+	if output == nil || len(output.Haikus) != 1 {
+		t.Fatalf("Expected to find 1 haiku before the truncation, got %+v", output)
+	}
+	expected := [3]string{"this is a haiku.", "hope the test finds it alright,", "i think that it should."}
+	if output.Haikus[0].ToStringArray() != expected {
+		t.Errorf("Expected haiku to end before the truncated sentence, got %+v", output.Haikus[0].ToStringArray())
+	}
+	if completeSentences("cut off before the end") != "" {
+		t.Errorf("Expected no complete sentences in text without sentence ending punctuation")
+	}
+	if completeSentences(`she said "go." then “wait!” and le`) != `she said "go." then “wait!”` {
+		t.Errorf("Expected closing quotes to be kept with their sentence, got %q", completeSentences(`she said "go." then “wait!” and le`))
+	}
+	_, err = NewProcessor(&config.WildHaiku{CorpusPath: "../syllable/cmudict.dict", TruncatedTweets: "guess"}, nil, nil)
+	if err == nil {
+		t.Errorf("Expected error for unknown TruncatedTweets setting")
+	}
+}
+
+func TestProcessLoop(t *testing.T) {
+	cmu, err := syllable.NewCMUCorpus("../syllable/cmudict.dict")
+	if err != nil {
+		t.Errorf("Error loading cmu dictionary %+v", err)
+	}
+	input := make(chan *source.Post, 4)
+	output := make(chan *Output, 4)
+	p := &Processor{inputChannel: input, outputChannel: output}
+	p.SwapCorpus(cmu.Snapshot())
+	results := map[ProcessResult]int{}
+	poems := 0
+	p.OnProcessed = func(result ProcessResult, elapsed time.Duration, found int) {
+		results[result]++
+		poems += found
+	}
+	input <- &source.Post{Text: "this is a haiku. hope the test finds it alright, i think that it should."}
+	input <- &source.Post{Text: "no haikus here"}
+	input <- &source.Post{Text: "the cat xqzvwk sat on the mat", Truncated: true}
+	input <- &source.Post{Text: "the cat xqzvwk sat on the mat"}
+	close(input)
+	err = p.ProcessLoop(context.Background())
+	if err != nil {
+		t.Errorf("Expected nil once input is drained, got %v", err)
+	}
+	if len(output) != 1 {
+		t.Errorf("Expected queued posts to be processed before returning, got %v outputs", len(output))
+	}
+	expected := map[ProcessResult]int{PoemsFound: 1, NoPoems: 1, TruncatedSkipped: 1, UnknownWords: 1}
+	for result, count := range expected {
+		if results[result] != count {
+			t.Errorf("Expected %d %s results, got %d", count, result, results[result])
+		}
+	}
+	if poems != 1 {
+		t.Errorf("Expected 1 poem to be reported found, got %d", poems)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p.inputChannel = make(chan *source.Post)
+	if err = p.ProcessLoop(ctx); err != context.Canceled {
+		t.Errorf("Expected canceled context to stop the loop, got %v", err)
+	}
+}
+
+func TestPool(t *testing.T) {
+	cmu, err := syllable.NewCMUCorpus("../syllable/cmudict.dict")
+	if err != nil {
+		t.Fatalf("Error loading cmu dictionary %+v", err)
+	}
+	input := make(chan *source.Post, 10)
+	output := make(chan *Output, 10)
+	p := &Processor{inputChannel: input, outputChannel: output}
+	p.SwapCorpus(cmu.Snapshot())
+	pool := NewPool(p, 0)
+	if pool.Size() != runtime.GOMAXPROCS(0) {
+		t.Errorf("Expected pool to default to GOMAXPROCS workers, got %d", pool.Size())
+	}
+	pool = NewPool(p, 3)
+	for i := 0; i < 4; i++ {
+		input <- &source.Post{Text: "this is a haiku. hope the test finds it alright, i think that it should."}
+		input <- &source.Post{Text: "no haikus here"}
+	}
+	if stats := pool.Stats(); stats.QueueDepth != 8 || stats.QueueCapacity != 10 {
+		t.Errorf("Expected queue depth 8/10, got %d/%d", stats.QueueDepth, stats.QueueCapacity)
+	}
+	close(input)
+	if err := pool.Run(context.Background()); err != nil {
+		t.Errorf("Expected nil once input is drained, got %v", err)
+	}
+	stats := pool.Stats()
+	if len(stats.Workers) != 3 || stats.QueueDepth != 0 {
+		t.Fatalf("Expected 3 workers and an empty queue, got %+v", stats)
+	}
+	processed, found := uint64(0), uint64(0)
+	for i, worker := range stats.Workers {
+		processed += worker.Processed
+		found += worker.Found
+		if worker.Processed > 0 && (stats.Throughput(i) <= 0 || stats.Utilization(i) <= 0) {
+			t.Errorf("Expected throughput and utilization for worker %d, got %+v", i, worker)
+		}
+	}
+	if processed != 8 || found != 4 || len(output) != 4 {
+		t.Errorf("Expected 8 posts processed with 4 found, got %d processed %d found %d output", processed, found, len(output))
+	}
+
+	swapped := syllable.CMUCorpus{Dict: map[string][]int{}}
+	if previous := p.SwapCorpus(swapped.Snapshot()); previous == nil {
+		t.Errorf("Expected SwapCorpus to return the previous snapshot")
+	}
+	if output := p.process(&source.Post{Text: "this is a haiku. hope the test finds it alright, i think that it should."}); output != nil && len(output.Haikus) > 0 {
+		t.Errorf("Expected swapped in empty corpus to find no haikus, got %+v", output.Haikus)
+	}
+}
+
+func TestReloadCorpus(t *testing.T) {
+	overlay, err := ioutil.TempFile("", "overlay")
+	if err != nil {
+		t.Fatalf("Error creating overlay file %v", err)
+	}
+	defer os.Remove(overlay.Name())
+	overlay.WriteString("uwu 2\n")
+	overlay.Close()
+	cfg := &config.WildHaiku{CorpusPath: "../syllable/cmudict.dict", OverlayPaths: []string{overlay.Name()}}
+	p, err := NewProcessor(cfg, nil, nil)
+	if err != nil {
+		t.Fatalf("Error creating processor %+v", err)
+	}
+	if !p.Corpus().HasSyllableCount("uwu") || p.Corpus().HasSyllableCount("owo") {
+		t.Fatalf("Expected only uwu from the overlay to be counted")
+	}
+	ioutil.WriteFile(overlay.Name(), []byte("uwu 2\nowo 2\n"), 0644)
+	if err := p.ReloadCorpus(cfg); err != nil {
+		t.Fatalf("Error reloading corpus %+v", err)
+	}
+	if !p.Corpus().HasSyllableCount("owo") {
+		t.Errorf("Expected words added to the overlay to be counted after a reload")
+	}
+	previous := p.Corpus()
+	if err := p.ReloadCorpus(&config.WildHaiku{CorpusPath: "missing.dict"}); err == nil || p.Corpus() != previous {
+		t.Errorf("Expected a failed reload to error and keep the current corpus, got %v", err)
+	}
+}
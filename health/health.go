@@ -0,0 +1,173 @@
+/*Package health serves liveness and readiness checks of the wildhaiku pipeline, for supervisors and orchestrators to restart or route around it
+ */
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/antipasta/wildhaiku/config"
+	"github.com/antipasta/wildhaiku/source"
+)
+
+const (
+	// HealthzPath serves the liveness check, failing when the process should be restarted
+	HealthzPath = "/healthz"
+	// ReadyzPath serves the readiness check, failing while the process is not keeping up with its stream
+	ReadyzPath = "/readyz"
+	// DefaultStallTimeout is used when config.HealthStallSeconds is not set
+	DefaultStallTimeout = 5 * time.Minute
+	// DefaultMaxBacklog is used when config.HealthMaxBacklog is not set
+	DefaultMaxBacklog = 0.9
+)
+
+// WritableChecker checks that output can be written, ie archive.DiskArchiver
+type WritableChecker interface {
+	CheckWritable() error
+}
+
+// StreamReport is the state of the source's connection to its stream
+type StreamReport struct {
+	Connected   bool
+	LastMessage time.Time
+	// SinceLastMessage is the seconds since anything was read off of the stream, or since the checker was created if nothing has been
+	SinceLastMessage float64
+}
+
+// ChannelBacklog is the number of items waiting in a pipeline channel
+type ChannelBacklog struct {
+	Name     string
+	Depth    int
+	Capacity int
+}
+
+// Report is the result of checking every part of the pipeline
+type Report struct {
+	// Stream is nil if the source does not report the state of its connection
+	Stream          *StreamReport `json:",omitempty"`
+	ArchiveWritable bool
+	ArchiveError    string `json:",omitempty"`
+	Backlog         []ChannelBacklog
+	// Unhealthy lists the checks that fail /healthz and /readyz
+	Unhealthy []string
+	// NotReady lists the checks that only fail /readyz
+	NotReady []string
+}
+
+// Healthy checks that no liveness check failed
+func (r *Report) Healthy() bool {
+	return len(r.Unhealthy) == 0
+}
+
+// Ready checks that no liveness or readiness check failed
+func (r *Report) Ready() bool {
+	return r.Healthy() && len(r.NotReady) == 0
+}
+
+// watchedChannel is a channel whose backlog is checked
+type watchedChannel struct {
+	name     string
+	depth    func() int
+	capacity int
+}
+
+// Checker checks the source, archive and channels of the pipeline against thresholds
+type Checker struct {
+	// Source, if set, reports the state of the stream connection
+	Source source.StatusReporter
+	// Archive, if set, is checked for being writable
+	Archive WritableChecker
+	// StallTimeout is how long the stream can go without sending anything before the process is unhealthy
+	StallTimeout time.Duration
+	// MaxBacklog is the share of a channel's capacity that can be waiting before the process is not ready
+	MaxBacklog float64
+	channels   []watchedChannel
+	started    time.Time
+	now        func() time.Time
+}
+
+// NewChecker creates a Checker with the thresholds set in config, or their defaults
+func NewChecker(cfg *config.WildHaiku) *Checker {
+	c := &Checker{
+		StallTimeout: time.Duration(cfg.HealthStallSeconds) * time.Second,
+		MaxBacklog:   cfg.HealthMaxBacklog,
+		started:      time.Now(),
+		now:          time.Now,
+	}
+	if c.StallTimeout <= 0 {
+		c.StallTimeout = DefaultStallTimeout
+	}
+	if c.MaxBacklog <= 0 {
+		c.MaxBacklog = DefaultMaxBacklog
+	}
+	return c
+}
+
+// WatchChannel checks the backlog of a channel, ie func() int { return len(ch) } with cap(ch). Must be called before checks are served
+func (c *Checker) WatchChannel(name string, depth func() int, capacity int) {
+	c.channels = append(c.channels, watchedChannel{name: name, depth: depth, capacity: capacity})
+}
+
+// Check runs every check, returning a Report of the results
+func (c *Checker) Check() *Report {
+	report := &Report{ArchiveWritable: true, Backlog: []ChannelBacklog{}, Unhealthy: []string{}, NotReady: []string{}}
+	if c.Source != nil {
+		status := c.Source.Status()
+		since := c.started
+		if status.LastMessage.After(since) {
+			since = status.LastMessage
+		}
+		sinceLastMessage := c.now().Sub(since)
+		report.Stream = &StreamReport{Connected: status.Connected, LastMessage: status.LastMessage, SinceLastMessage: sinceLastMessage.Seconds()}
+		if sinceLastMessage > c.StallTimeout {
+			report.Unhealthy = append(report.Unhealthy, fmt.Sprintf("nothing read from stream for %v", sinceLastMessage.Round(time.Second)))
+		}
+		if !status.Connected {
+			report.NotReady = append(report.NotReady, "stream is not connected")
+		}
+	}
+	if c.Archive != nil {
+		if err := c.Archive.CheckWritable(); err != nil {
+			report.ArchiveWritable = false
+			report.ArchiveError = err.Error()
+			report.Unhealthy = append(report.Unhealthy, "archive is not writable")
+		}
+	}
+	for _, channel := range c.channels {
+		backlog := ChannelBacklog{Name: channel.name, Depth: channel.depth(), Capacity: channel.capacity}
+		report.Backlog = append(report.Backlog, backlog)
+		if backlog.Capacity > 0 && float64(backlog.Depth) > c.MaxBacklog*float64(backlog.Capacity) {
+			report.NotReady = append(report.NotReady, fmt.Sprintf("%s backlog is %d of %d", backlog.Name, backlog.Depth, backlog.Capacity))
+		}
+	}
+	return report
+}
+
+// writeReport writes the report as json, with status 200 if ok or 503 otherwise
+func writeReport(w http.ResponseWriter, report *Report, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// Healthz serves the liveness check, which fails if the stream has stalled or the archive is not writable
+func (c *Checker) Healthz(w http.ResponseWriter, r *http.Request) {
+	report := c.Check()
+	writeReport(w, report, report.Healthy())
+}
+
+// Readyz serves the readiness check, which also fails while the stream is not connected or a channel's backlog is over MaxBacklog
+func (c *Checker) Readyz(w http.ResponseWriter, r *http.Request) {
+	report := c.Check()
+	writeReport(w, report, report.Ready())
+}
+
+// Register adds the Healthz and Readyz handlers to mux
+func (c *Checker) Register(mux *http.ServeMux) {
+	mux.HandleFunc(HealthzPath, c.Healthz)
+	mux.HandleFunc(ReadyzPath, c.Readyz)
+}
@@ -0,0 +1,85 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/antipasta/wildhaiku/config"
+	"github.com/antipasta/wildhaiku/source"
+	"github.com/pkg/errors"
+)
+
+type fakeSource struct {
+	status source.Status
+}
+
+func (fs *fakeSource) Status() source.Status {
+	return fs.status
+}
+
+type fakeArchive struct {
+	err error
+}
+
+func (fa *fakeArchive) CheckWritable() error {
+	return fa.err
+}
+
+func get(t *testing.T, mux *http.ServeMux, path string) (int, *Report) {
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	report := Report{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Error decoding report from %s: %v", path, err)
+	}
+	return recorder.Code, &report
+}
+
+func TestChecker(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	stream := &fakeSource{status: source.Status{Connected: true, LastMessage: now.Add(-10 * time.Second)}}
+	archive := &fakeArchive{}
+	backlog := 0
+	checker := NewChecker(&config.WildHaiku{HealthStallSeconds: 60})
+	checker.Source = stream
+	checker.Archive = archive
+	checker.started = now.Add(-time.Hour)
+	checker.now = func() time.Time { return now }
+	checker.WatchChannel("process", func() int { return backlog }, 100)
+	if checker.MaxBacklog != DefaultMaxBacklog {
+		t.Errorf("Expected MaxBacklog to default to %v, got %v", DefaultMaxBacklog, checker.MaxBacklog)
+	}
+	mux := http.NewServeMux()
+	checker.Register(mux)
+
+	code, report := get(t, mux, ReadyzPath)
+	if code != http.StatusOK || report.Stream == nil || !report.Stream.Connected || report.Stream.SinceLastMessage != 10 {
+		t.Errorf("Expected ready with a connected stream, got %d %+v %+v", code, report, report.Stream)
+	}
+
+	stream.status.Connected = false
+	backlog = 95
+	if code, report = get(t, mux, ReadyzPath); code != http.StatusServiceUnavailable || len(report.NotReady) != 2 {
+		t.Errorf("Expected not ready while disconnected and backed up, got %d %+v", code, report)
+	}
+	if code, _ = get(t, mux, HealthzPath); code != http.StatusOK {
+		t.Errorf("Expected healthy while reconnecting, got %d", code)
+	}
+
+	stream.status.LastMessage = now.Add(-2 * time.Minute)
+	archive.err = errors.Errorf("read only file system")
+	code, report = get(t, mux, HealthzPath)
+	if code != http.StatusServiceUnavailable || len(report.Unhealthy) != 2 || report.ArchiveWritable || report.ArchiveError == "" {
+		t.Errorf("Expected unhealthy when stalled and not writable, got %d %+v", code, report)
+	}
+
+	stream.status = source.Status{}
+	archive.err = nil
+	checker.started = now.Add(-30 * time.Second)
+	if code, _ = get(t, mux, HealthzPath); code != http.StatusOK {
+		t.Errorf("Expected healthy within StallTimeout of starting, before anything is read, got %d", code)
+	}
+}
@@ -0,0 +1,127 @@
+/*Package logging builds the structured, leveled loggers used by each component of the wildhaiku pipeline
+ */
+package logging
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/antipasta/wildhaiku/config"
+	"github.com/pkg/errors"
+)
+
+const (
+	// LogfmtFormat writes each record as key=value pairs on one line
+	LogfmtFormat = "logfmt"
+	// JSONFormat writes each record as a json object on one line
+	JSONFormat = "json"
+)
+
+// MaxLineLength is how much of a raw stream line is kept when logging it, so that a bad line does not flood the log
+const MaxLineLength = 256
+
+// lockedWriter serializes writes from the handlers of every component, so that records are not interleaved
+type lockedWriter struct {
+	lock   sync.Mutex
+	writer io.Writer
+}
+
+func (lw *lockedWriter) Write(p []byte) (int, error) {
+	lw.lock.Lock()
+	defer lw.lock.Unlock()
+	return lw.writer.Write(p)
+}
+
+// Loggers creates a logger for each component, all writing to the same output in the same format
+type Loggers struct {
+	writer *lockedWriter
+	format string
+	level  slog.Level
+	// levels are per component overrides of level
+	levels map[string]slog.Level
+}
+
+// ParseLevel converts one of debug, info, warn or error into a slog.Level, defaulting to info if name is empty. Errors if name is not a known level
+func ParseLevel(name string) (slog.Level, error) {
+	level := slog.LevelInfo
+	if name == "" {
+		return level, nil
+	}
+	err := level.UnmarshalText([]byte(name))
+	if err != nil {
+		return level, errors.Errorf("Unknown log level %v", name)
+	}
+	return level, nil
+}
+
+// New creates Loggers writing to w in config LogFormat, at config LogLevel unless overridden for a component in config LogLevels.
+// Errors if the format or any level is unknown
+func New(cfg *config.WildHaiku, w io.Writer) (*Loggers, error) {
+	format := strings.ToLower(cfg.LogFormat)
+	switch format {
+	case "":
+		format = LogfmtFormat
+	case LogfmtFormat, JSONFormat:
+	default:
+		return nil, errors.Errorf("Unknown log format %v", cfg.LogFormat)
+	}
+	level, err := ParseLevel(cfg.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	l := &Loggers{writer: &lockedWriter{writer: w}, format: format, level: level, levels: map[string]slog.Level{}}
+	for component, levelName := range cfg.LogLevels {
+		l.levels[component], err = ParseLevel(levelName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error setting log level of %v", component)
+		}
+	}
+	return l, nil
+}
+
+// handler returns a handler writing records at level and above
+func (l *Loggers) handler(level slog.Level) slog.Handler {
+	options := &slog.HandlerOptions{Level: level}
+	if l.format == JSONFormat {
+		return slog.NewJSONHandler(l.writer, options)
+	}
+	return slog.NewTextHandler(l.writer, options)
+}
+
+// Default returns the logger for anything that is not a component, at config LogLevel
+func (l *Loggers) Default() *slog.Logger {
+	return slog.New(l.handler(l.level))
+}
+
+// For returns the logger of a component, ie twitter, mastodon, haiku or archive. Its records are tagged with the component, and written at its level in config LogLevels if set
+func (l *Loggers) For(component string) *slog.Logger {
+	level, exists := l.levels[component]
+	if !exists {
+		level = l.level
+	}
+	return slog.New(l.handler(level)).With("component", component)
+}
+
+// OrDefault returns logger, or slog.Default() if it is not set, so that a component whose Logger was left unset logs through the default logger
+func OrDefault(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return slog.Default()
+	}
+	return logger
+}
+
+// Truncate shortens a raw line to MaxLineLength bytes for logging
+func Truncate(line []byte) string {
+	if len(line) <= MaxLineLength {
+		return string(line)
+	}
+	end := MaxLineLength
+	for end > 0 && !utf8.RuneStart(line[end]) {
+		// do not cut a character in half
+		end--
+	}
+	return string(line[:end]) + "..."
+}
@@ -0,0 +1,76 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/antipasta/wildhaiku/config"
+)
+
+func TestLoggers(t *testing.T) {
+	out := bytes.Buffer{}
+	loggers, err := New(&config.WildHaiku{LogFormat: "json", LogLevel: "warn", LogLevels: map[string]string{"twitter": "debug"}}, &out)
+	if err != nil {
+		t.Fatalf("Error creating loggers: %v", err)
+	}
+	loggers.For("twitter").Debug("Got keepalive ping")
+	loggers.For("archive").Info("Writing to file", "path", "haiku.json")
+	loggers.For("archive").Warn("Error syncing file", "path", "haiku.json")
+	loggers.Default().Info("Shut down cleanly")
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected a twitter debug record and an archive warning, got %v", lines)
+	}
+	record := map[string]interface{}{}
+	if err = json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("Expected json records, got %v: %v", lines[0], err)
+	}
+	if record["component"] != "twitter" || record["level"] != "DEBUG" || record["msg"] != "Got keepalive ping" {
+		t.Errorf("Unexpected twitter record %v", record)
+	}
+	if !strings.Contains(lines[1], `"component":"archive"`) || !strings.Contains(lines[1], `"path":"haiku.json"`) {
+		t.Errorf("Unexpected archive record %v", lines[1])
+	}
+
+	out.Reset()
+	loggers, err = New(&config.WildHaiku{}, &out)
+	if err != nil {
+		t.Fatalf("Error creating default loggers: %v", err)
+	}
+	loggers.For("haiku").Debug("Process worker")
+	loggers.For("haiku").Info("Process pool", "workers", 4)
+	if record := strings.TrimSpace(out.String()); !strings.Contains(record, "level=INFO") || !strings.Contains(record, "component=haiku") ||
+		!strings.Contains(record, "workers=4") || strings.Contains(record, "Process worker") {
+		t.Errorf("Expected one logfmt record at the default info level, got %v", record)
+	}
+
+	for _, cfg := range []config.WildHaiku{{LogFormat: "xml"}, {LogLevel: "loud"}, {LogLevels: map[string]string{"haiku": "quiet"}}} {
+		if _, err = New(&cfg, &out); err == nil {
+			t.Errorf("Expected an error for config %+v", cfg)
+		}
+	}
+}
+
+func TestOrDefault(t *testing.T) {
+	if OrDefault(nil) != slog.Default() {
+		t.Errorf("Expected an unset logger to fall back to the default logger")
+	}
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	if OrDefault(logger) != logger {
+		t.Errorf("Expected a set logger to be used")
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if Truncate([]byte("short line")) != "short line" {
+		t.Errorf("Expected short lines to be kept whole")
+	}
+	long := strings.Repeat("a", MaxLineLength-1) + "é" + strings.Repeat("b", 100)
+	truncated := Truncate([]byte(long))
+	if truncated != strings.Repeat("a", MaxLineLength-1)+"..." {
+		t.Errorf("Expected line to be cut before a split character, got %v", truncated)
+	}
+}
@@ -9,66 +9,293 @@
 package main
 
 import (
+	"context"
 	"flag"
-	"log"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/antipasta/wildhaiku/archive"
+	"github.com/antipasta/wildhaiku/bluesky"
 	"github.com/antipasta/wildhaiku/config"
 	"github.com/antipasta/wildhaiku/haiku"
+	"github.com/antipasta/wildhaiku/health"
+	"github.com/antipasta/wildhaiku/logging"
+	"github.com/antipasta/wildhaiku/mastodon"
+	"github.com/antipasta/wildhaiku/metrics"
+	"github.com/antipasta/wildhaiku/replay"
+	"github.com/antipasta/wildhaiku/source"
 	"github.com/antipasta/wildhaiku/twitter"
+	"github.com/pkg/errors"
 )
 
+// defaultShutdownTimeout is used when config.ShutdownTimeoutSeconds is not set
+const defaultShutdownTimeout = 30 * time.Second
+
 var flagConfigPath string
+var flagLookupWord string
+var flagReplayPath string
+var flagReplayFormat string
+var flagReplayRate float64
 
 func init() {
 	flag.StringVar(&flagConfigPath, "config", "config.json", "Path to config file")
+	flag.StringVar(&flagLookupWord, "lookup", "", "Print the syllable counts of a word and which dictionary supplied them, then exit")
+	flag.StringVar(&flagReplayPath, "replay", "", "Replay posts from a file, glob or - for stdin instead of streaming. Further paths can be given as arguments")
+	flag.StringVar(&flagReplayFormat, "replay-format", "", "Format of replayed lines, tweets, tweets_v2 or text. Overrides ReplayFormat")
+	flag.Float64Var(&flagReplayRate, "replay-rate", 0, "Replay tweets at this multiple of the speed they were streamed at, 0 for as fast as possible. Overrides ReplayRate")
 }
 
-func main() {
-	flag.Parse()
-	cfg, err := config.Load(flagConfigPath)
+// applyReplayFlags overrides the replay settings in cfg with any replay flags given on the command line
+func applyReplayFlags(cfg *config.WildHaiku) {
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "replay":
+			cfg.Source = "replay"
+			cfg.ReplayPaths = append([]string{flagReplayPath}, flag.Args()...)
+		case "replay-format":
+			cfg.ReplayFormat = flagReplayFormat
+		case "replay-rate":
+			cfg.ReplayRate = flagReplayRate
+		}
+	})
+}
+
+// lookupWord prints the syllable counts of word, and the source of those counts, using the corpus and overlays specified in cfg
+func lookupWord(cfg *config.WildHaiku, word string) error {
+	forms, err := haiku.NewForms(cfg)
 	if err != nil {
-		log.Fatalf("Error loading config file[%v]: %v", flagConfigPath, err)
+		return err
 	}
-	ts := twitter.NewStreamer(cfg)
-	diskArchiver, err := archive.NewDiskArchiver(cfg)
+	cmu, err := haiku.NewCorpus(cfg, forms)
+	if err != nil {
+		return err
+	}
+	counts, err := cmu.SyllableCounts(word)
 	if err != nil {
-		log.Fatalf("Error initializing disk archiver: %v", err)
+		return err
 	}
-	haikuProcessor, err := haiku.NewProcessor(cfg, ts.ProcessChannel, diskArchiver.ArchiveChannel)
+	source, err := cmu.Source(word)
 	if err != nil {
-		log.Fatalf("Error initializing haiku processor: %v", err)
+		return err
 	}
+	fmt.Printf("%s: %v syllables (from %s)\n", word, counts, source)
+	return nil
+}
 
+// newSource returns the source of posts selected by cfg.Source
+func newSource(cfg *config.WildHaiku) (source.Source, error) {
+	switch cfg.Source {
+	case "", "twitter":
+		return twitter.NewStreamer(cfg), nil
+	case "mastodon":
+		if cfg.MastodonInstance == "" {
+			return nil, errors.Errorf("MastodonInstance must be set to stream from mastodon")
+		}
+		return mastodon.NewStreamer(cfg), nil
+	case "bluesky":
+		return bluesky.NewStreamer(cfg)
+	case "replay":
+		if len(cfg.ReplayPaths) == 0 {
+			return nil, errors.Errorf("ReplayPaths must be set to replay")
+		}
+		return replay.NewSource(cfg)
+	}
+	return nil, errors.Errorf("Unknown source %v", cfg.Source)
+}
+
+// fatal logs an error and exits
+func fatal(msg string, args ...interface{}) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// newMetrics wires the hooks of every pipeline stage to prometheus metrics, returning the handler that serves them
+func newMetrics(postSource source.Source, processor *haiku.Processor, diskArchiver *archive.DiskArchiver) http.Handler {
+	pipelineMetrics := metrics.New()
+	if streamer, ok := postSource.(*twitter.Streamer); ok {
+		streamer.OnReconnect = pipelineMetrics.Reconnect
+		streamer.OnStreamEvent = pipelineMetrics.StreamEvent
+	}
+	processor.OnProcessed = pipelineMetrics.Processed
+	diskArchiver.OnFiltered = pipelineMetrics.Filtered
+	posts := postSource.Posts()
+	pipelineMetrics.WatchChannel("process", func() int { return len(posts) })
+	pipelineMetrics.WatchChannel("archive", func() int { return len(diskArchiver.ArchiveChannel) })
+	return pipelineMetrics.Handler()
+}
+
+// newChecker returns a health.Checker of the source's connection, the archive and the backlog of the channels between them
+func newChecker(cfg *config.WildHaiku, postSource source.Source, diskArchiver *archive.DiskArchiver) *health.Checker {
+	checker := health.NewChecker(cfg)
+	if reporter, ok := postSource.(source.StatusReporter); ok {
+		checker.Source = reporter
+	}
+	checker.Archive = diskArchiver
+	posts := postSource.Posts()
+	checker.WatchChannel("process", func() int { return len(posts) }, cap(posts))
+	checker.WatchChannel("archive", func() int { return len(diskArchiver.ArchiveChannel) }, cap(diskArchiver.ArchiveChannel))
+	return checker
+}
+
+// serveHTTP serves handler on addr until ctx is done
+func serveHTTP(ctx context.Context, addr string, handler http.Handler) {
+	server := &http.Server{Addr: addr, Handler: handler}
 	go func() {
-		err := diskArchiver.OutputLoop()
-		if err != nil {
-			log.Fatalf("Error from disk archiver: %v", err)
+		<-ctx.Done()
+		server.Close()
+	}()
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			fatal("Error serving", "address", addr, "error", err)
 		}
 	}()
+}
 
-	for i := 0; i < cfg.ProcessWorkerCount; i++ {
-		go func(i int) {
-			err := haikuProcessor.ProcessLoop()
-			if err != nil {
-				log.Fatalf("Error from Process Worker %d: %v", i, err)
+// reloadOnHangup reloads the corpus and overlay dictionaries of processor on SIGHUP, until ctx is done. The current corpus is kept if reloading fails
+func reloadOnHangup(ctx context.Context, cfg *config.WildHaiku, processor *haiku.Processor) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			if err := processor.ReloadCorpus(cfg); err != nil {
+				slog.Error("Error reloading corpus, keeping current corpus", "error", err)
+				continue
 			}
-		}(i)
+			slog.Info("Reloaded corpus", "path", cfg.CorpusPath, "overlays", cfg.OverlayPaths)
+		}
 	}
+}
 
-	for {
-		resp, err := ts.Connect()
+func main() {
+	flag.Parse()
+	cfg, err := config.Load(flagConfigPath)
+	if err != nil {
+		fatal("Error loading config file", "path", flagConfigPath, "error", err)
+	}
+	if flagLookupWord != "" {
+		err = lookupWord(cfg, flagLookupWord)
 		if err != nil {
-			resp.Body.Close()
-			log.Printf("Got error when connecting to twitter stream, sleeping and reconnecting: %v", err)
-			time.Sleep(5 * time.Second)
-			continue
+			fatal("Error looking up word", "word", flagLookupWord, "error", err)
 		}
-		err = ts.StreamLoop(resp.Body)
-		resp.Body.Close()
-		if err != nil {
-			log.Printf("Got stream error %+v. Reconnecting", err)
+		return
+	}
+	loggers, err := logging.New(cfg, os.Stderr)
+	if err != nil {
+		fatal("Error configuring logging", "error", err)
+	}
+	// anything logged outside of a component, ie with the log package, goes through the same handler
+	slog.SetDefault(loggers.Default())
+	applyReplayFlags(cfg)
+	postSource, err := newSource(cfg)
+	if err != nil {
+		fatal("Error initializing source", "error", err)
+	}
+	switch typedSource := postSource.(type) {
+	case *twitter.Streamer:
+		typedSource.Logger = loggers.For("twitter")
+	case *mastodon.Streamer:
+		typedSource.Logger = loggers.For("mastodon")
+	case *bluesky.Streamer:
+		typedSource.Logger = loggers.For("bluesky")
+	case *replay.Source:
+		typedSource.Logger = loggers.For("replay")
+	}
+	diskArchiver, err := archive.NewDiskArchiver(cfg)
+	if err != nil {
+		fatal("Error initializing disk archiver", "error", err)
+	}
+	diskArchiver.Logger = loggers.For("archive")
+	if !cfg.NoEchoHaikus {
+		diskArchiver.OnArchived = archive.NewConsoleSink().Print
+	}
+	if deleter, ok := postSource.(source.Deleter); ok {
+		diskArchiver.DeleteChannel = deleter.Deletions()
+	}
+	haikuProcessor, err := haiku.NewProcessor(cfg, postSource.Posts(), diskArchiver.ArchiveChannel)
+	if err != nil {
+		fatal("Error initializing haiku processor", "error", err)
+	}
+	haikuProcessor.Logger = loggers.For("haiku")
+
+	// ctx is done on SIGINT or SIGTERM, stopping the source. drainCtx is done once the shutdown deadline passes after that, stopping everything else
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	drainCtx, cancelDrain := context.WithCancel(context.Background())
+	defer cancelDrain()
+	shutdownTimeout := time.Duration(cfg.ShutdownTimeoutSeconds) * time.Second
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = defaultShutdownTimeout
+	}
+	go func() {
+		<-ctx.Done()
+		slog.Info("Shutting down, draining posts", "timeout", shutdownTimeout)
+		time.AfterFunc(shutdownTimeout, cancelDrain)
+		// a stage that ignores drainCtx must not keep the daemon up forever
+		time.AfterFunc(shutdownTimeout+5*time.Second, func() {
+			fatal("Shutdown did not finish in time", "timeout", shutdownTimeout)
+		})
+	}()
+
+	// metrics and health checks share a server when they are configured on the same address
+	muxes := map[string]*http.ServeMux{}
+	muxFor := func(addr string) *http.ServeMux {
+		if muxes[addr] == nil {
+			muxes[addr] = http.NewServeMux()
 		}
+		return muxes[addr]
+	}
+	if cfg.MetricsAddress != "" {
+		muxFor(cfg.MetricsAddress).Handle(metrics.Path, newMetrics(postSource, haikuProcessor, diskArchiver))
+		slog.Info("Serving metrics", "address", cfg.MetricsAddress, "path", metrics.Path)
+	}
+	if cfg.HealthAddress != "" {
+		newChecker(cfg, postSource, diskArchiver).Register(muxFor(cfg.HealthAddress))
+		slog.Info("Serving health checks", "address", cfg.HealthAddress, "paths", []string{health.HealthzPath, health.ReadyzPath})
+	}
+	for addr, mux := range muxes {
+		serveHTTP(drainCtx, addr, mux)
+	}
+
+	go reloadOnHangup(ctx, cfg, haikuProcessor)
+
+	archiverDone := make(chan error, 1)
+	go func() {
+		archiverDone <- diskArchiver.OutputLoop(drainCtx)
+	}()
+
+	pool := haiku.NewPool(haikuProcessor, cfg.ProcessWorkerCount)
+	slog.Info("Processing posts", "workers", pool.Size())
+	if cfg.ProcessStatsSeconds > 0 {
+		go pool.LogStats(drainCtx, time.Duration(cfg.ProcessStatsSeconds)*time.Second)
+	}
+	poolDone := make(chan error, 1)
+	go func() {
+		poolDone <- pool.Run(drainCtx)
+	}()
+
+	err = postSource.Run(ctx)
+	if err != nil {
+		fatal("Error from source", "error", err)
+	}
+	// the source has closed its channels, close downstream channels in order as each stage finishes what it has
+	err = <-poolDone
+	if err != nil && err != context.Canceled {
+		fatal("Error from process pool", "error", err)
+	}
+	close(diskArchiver.ArchiveChannel)
+	err = <-archiverDone
+	if err != nil {
+		fatal("Archive did not finish within shutdown deadline", "error", err)
 	}
+	slog.Info("Shut down cleanly")
 }
@@ -0,0 +1,236 @@
+/*Package mastodon contains utilities for connecting to and parsing statuses from a Mastodon instance's public streaming API
+ */
+package mastodon
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"html"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/antipasta/wildhaiku/config"
+	"github.com/antipasta/wildhaiku/logging"
+	"github.com/antipasta/wildhaiku/source"
+	"github.com/pkg/errors"
+)
+
+// Status is a representation of a subset of fields of a status from Mastodon's API
+type Status struct {
+	ID        string    `json:"id"`
+	URI       string    `json:"uri"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+	Content   string    `json:"content"`
+	Language  string    `json:"language"`
+	Account   struct {
+		Acct     string `json:"acct"`
+		Username string `json:"username"`
+	} `json:"account"`
+	Sensitive   bool    `json:"sensitive"`
+	SpoilerText string  `json:"spoiler_text"`
+	Reblog      *Status `json:"reblog,omitempty"`
+}
+
+// Permalink returns the URL of the status on its home instance, falling back to its URI
+func (s *Status) Permalink() string {
+	if s.URL != "" {
+		return s.URL
+	}
+	return s.URI
+}
+
+// Post converts the status to a source.Post with the html stripped from its content, keeping the status as the post's Original
+func (s *Status) Post() *source.Post {
+	return &source.Post{
+		ID:        s.ID,
+		Author:    s.Account.Acct,
+		Permalink: s.Permalink(),
+		Text:      StripHTML(s.Content),
+		Language:  s.Language,
+		Timestamp: s.CreatedAt,
+		Original:  s,
+	}
+}
+
+// blockTags are html tags that separate lines of text in status content
+var blockTags = map[string]bool{"p": true, "/p": true, "br": true, "br/": true}
+
+// StripHTML converts the html content of a status to plain text, treating paragraphs and line breaks as newlines and unescaping entities
+func StripHTML(content string) string {
+	text := bytes.Buffer{}
+	for len(content) > 0 {
+		tagStart := strings.IndexByte(content, '<')
+		if tagStart < 0 {
+			text.WriteString(content)
+			break
+		}
+		text.WriteString(content[:tagStart])
+		tagEnd := strings.IndexByte(content[tagStart:], '>')
+		if tagEnd < 0 {
+			break
+		}
+		tag := strings.ToLower(strings.TrimSpace(content[tagStart+1 : tagStart+tagEnd]))
+		if fields := strings.Fields(tag); len(fields) > 0 {
+			tag = fields[0]
+		}
+		if blockTags[tag] && text.Len() > 0 && !strings.HasSuffix(text.String(), "\n") {
+			text.WriteString("\n")
+		}
+		content = content[tagStart+tagEnd+1:]
+	}
+	return strings.TrimSpace(html.UnescapeString(text.String()))
+}
+
+// Streamer is responsible for connecting to and reading from a Mastodon instance's public stream over server sent events. Implements source.Source
+type Streamer struct {
+	Config         *config.WildHaiku
+	httpClient     *http.Client
+	ProcessChannel chan *source.Post
+	// Logger is where connection errors and undecodable statuses are logged, defaults to slog.Default()
+	Logger *slog.Logger
+	// languages are the status languages to keep, from config.MastodonLanguages
+	languages map[string]bool
+}
+
+// NewStreamer returns a mastodon.Streamer object
+func NewStreamer(cfg *config.WildHaiku) *Streamer {
+	languages := map[string]bool{}
+	for _, language := range cfg.MastodonLanguages {
+		languages[language] = true
+	}
+	if len(languages) == 0 {
+		languages["en"] = true
+	}
+	return &Streamer{
+		Config:         cfg,
+		httpClient:     &http.Client{},
+		ProcessChannel: make(chan *source.Post, 10000),
+		Logger:         slog.Default(),
+		languages:      languages,
+	}
+}
+
+// Connect connects to the instance's public stream and returns the response for reading
+func (ms *Streamer) Connect() (*http.Response, error) {
+	return ms.ConnectContext(context.Background())
+}
+
+// ConnectContext connects to the instance's public stream and returns the response for reading, which is closed once ctx is done
+func (ms *Streamer) ConnectContext(ctx context.Context) (*http.Response, error) {
+	streamURL := strings.TrimSuffix(ms.Config.MastodonInstance, "/") + "/api/v1/streaming/public"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, streamURL, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error creating request for %s", streamURL)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if ms.Config.MastodonAccessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+ms.Config.MastodonAccessToken)
+	}
+	resp, err := ms.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Caught error when connecting to mastodon stream")
+	}
+	if resp.StatusCode != http.StatusOK {
+		all, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, errors.Errorf("Received non-OK error code [%v] [%v] when connecting to mastodon stream: %v", resp.StatusCode, resp.Status, string(all))
+	}
+	return resp, nil
+}
+
+// Posts returns the channel that statuses are emitted on as source.Posts
+func (ms *Streamer) Posts() <-chan *source.Post {
+	return ms.ProcessChannel
+}
+
+// Run connects to the mastodon stream and reads from it until ctx is done, reconnecting whenever the connection fails or the stream ends
+func (ms *Streamer) Run(ctx context.Context) error {
+	defer close(ms.ProcessChannel)
+	for ctx.Err() == nil {
+		resp, err := ms.ConnectContext(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			logging.OrDefault(ms.Logger).Warn("Error connecting to mastodon stream, sleeping and reconnecting", "error", err)
+			source.Sleep(ctx, 5*time.Second)
+			continue
+		}
+		err = ms.StreamLoop(resp.Body)
+		resp.Body.Close()
+		if err != nil && ctx.Err() == nil {
+			logging.OrDefault(ms.Logger).Warn("Got stream error, reconnecting", "error", err)
+		}
+	}
+	return nil
+}
+
+// StreamLoop reads server sent events off of a mastodon stream and sends statuses from update events to the ProcessChannel as source.Posts
+func (ms *Streamer) StreamLoop(stream io.Reader) error {
+	buf := bufio.NewReader(stream)
+	event := ""
+	data := bytes.Buffer{}
+	for {
+		line, err := buf.ReadString('\n')
+		if err != nil && len(line) == 0 {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "":
+			// blank line dispatches the event
+			if event == "update" && data.Len() > 0 {
+				post, err := ms.parseStatus(data.Bytes())
+				if err == nil && post != nil {
+					ms.ProcessChannel <- post
+				}
+			}
+			event = ""
+			data.Reset()
+		case strings.HasPrefix(line, ":"):
+			// heartbeat comment
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteString("\n")
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (ms *Streamer) parseStatus(inBytes []byte) (*source.Post, error) {
+	s := Status{}
+	err := json.Unmarshal(inBytes, &s)
+	if err != nil {
+		logging.OrDefault(ms.Logger).Warn("Error json decoding status", "status", logging.Truncate(inBytes), "length", len(inBytes), "error", err)
+		return nil, errors.Errorf("Error json decoding status [%v]: %v", logging.Truncate(inBytes), err)
+	}
+	if s.Reblog != nil {
+		// A boost has no text of its own, work off original for proper attribution
+		s = *s.Reblog
+	}
+	if s.Sensitive || s.SpoilerText != "" {
+		// Content behind a content warning should not be republished
+		return nil, nil
+	}
+	if !ms.languages[s.Language] {
+		return nil, nil
+	}
+	post := s.Post()
+	if post.Text == "" {
+		return nil, nil
+	}
+	return post, nil
+}
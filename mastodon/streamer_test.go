@@ -0,0 +1,112 @@
+package mastodon
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/antipasta/wildhaiku/config"
+)
+
+const sampleStream = `:)
+
+event: update
+data: {"id":"1","url":"https://example.social/@poet/1","created_at":"2023-01-02T03:04:05.000Z","language":"en","content":"<p>an old silent pond&#39;s</p><p>a frog jumps into the pond <a href=\"https://example.social/tags/splash\" class=\"mention hashtag\">#<span>splash</span></a></p>","account":{"acct":"poet@example.social","username":"poet"}}
+
+:thump
+
+event: update
+data: {"id":"2","url":"https://example.social/@poet/2","language":"de","content":"<p>ein alter teich</p>","account":{"acct":"poet"}}
+
+event: delete
+data: 1
+
+event: update
+data: {"id":"3","url":"https://example.social/@booster/3","language":null,"content":"","account":{"acct":"booster"},
+data: "reblog":{"id":"4","url":"https://other.social/@writer/4","language":"en","content":"<p>boosted<br>words</p>","account":{"acct":"writer@other.social"}}}
+
+event: update
+data: {"id":"5","url":"https://example.social/@cw/5","language":"en","spoiler_text":"spoilers","content":"<p>hidden</p>","account":{"acct":"cw"}}
+
+`
+
+func TestStripHTML(t *testing.T) {
+	testCases := map[string]string{
+		"<p>hello world</p>":                     "hello world",
+		"<p>one</p><p>two</p>":                   "one\ntwo",
+		"line<br />break":                        "line\nbreak",
+		"<p>fish &amp; chips &quot;ok&quot;</p>": "fish & chips \"ok\"",
+		`<p><a href="https://x.social/@a">@<span>a</span></a> hi</p>`: "@a hi",
+	}
+	for content, expected := range testCases {
+		if text := StripHTML(content); text != expected {
+			t.Errorf("Expected %q to strip to %q, got %q", content, expected, text)
+		}
+	}
+}
+
+func TestStreamer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/streaming/public" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer testtoken" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, sampleStream)
+	}))
+	defer server.Close()
+
+	s := NewStreamer(&config.WildHaiku{MastodonInstance: server.URL + "/", MastodonAccessToken: "testtoken"})
+	resp, err := s.Connect()
+	if err != nil {
+		t.Fatalf("Error connecting to fake stream: %v", err)
+	}
+	defer resp.Body.Close()
+	err = s.StreamLoop(resp.Body)
+	if err != nil && err != io.EOF {
+		t.Errorf("Error when streaming %v", err)
+	}
+	if len(s.ProcessChannel) != 2 {
+		t.Fatalf("Expected 2 statuses in channel, got %v", len(s.ProcessChannel))
+	}
+	post := <-s.ProcessChannel
+	if post.Text != "an old silent pond's\na frog jumps into the pond #splash" {
+		t.Errorf("Expected html to be stripped, got %q", post.Text)
+	}
+	if post.Permalink != "https://example.social/@poet/1" || post.Author != "poet@example.social" || post.ID != "1" {
+		t.Errorf("Unexpected post fields %+v", post)
+	}
+	if post.Timestamp.Year() != 2023 {
+		t.Errorf("Expected timestamp from created_at, got %v", post.Timestamp)
+	}
+	if _, isStatus := post.Original.(*Status); !isStatus {
+		t.Errorf("Expected post to keep original status, got %+v", post.Original)
+	}
+	post = <-s.ProcessChannel
+	if post.Text != "boosted\nwords" || post.Permalink != "https://other.social/@writer/4" {
+		t.Errorf("Expected boost to be attributed to original status, got %+v", post)
+	}
+
+	unauthorized := NewStreamer(&config.WildHaiku{MastodonInstance: server.URL})
+	_, err = unauthorized.Connect()
+	if err == nil {
+		t.Errorf("Expected error connecting without token")
+	}
+
+	logged := bytes.Buffer{}
+	s.Logger = slog.New(slog.NewTextHandler(&logged, nil))
+	badStatus := `{"id":"6","content":"` + strings.Repeat("pond ", 1000)
+	_, err = s.parseStatus([]byte(badStatus))
+	if err == nil || len(err.Error()) > 1000 || logged.Len() == 0 || logged.Len() > 1000 {
+		t.Errorf("Expected undecodable status to be logged and returned truncated, got error %v and log %v", err, logged.String())
+	}
+}
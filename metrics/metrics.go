@@ -0,0 +1,154 @@
+/*Package metrics exports counters, channel depths and latency histograms of the wildhaiku pipeline for prometheus to scrape
+ */
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/antipasta/wildhaiku/archive"
+	"github.com/antipasta/wildhaiku/haiku"
+	"github.com/antipasta/wildhaiku/syllable"
+	"github.com/antipasta/wildhaiku/twitter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Path is where the metrics are served
+const Path = "/metrics"
+
+// Metrics holds the collectors of the pipeline. Its methods match the hooks of twitter.Streamer, haiku.Processor and archive.DiskArchiver, so that they can be set directly
+type Metrics struct {
+	Registry        *prometheus.Registry
+	tweetsReceived  prometheus.Counter
+	tweetsDropped   *prometheus.CounterVec
+	retweets        prometheus.Counter
+	parseErrors     prometheus.Counter
+	deletesDropped  prometheus.Counter
+	keepalives      prometheus.Counter
+	reconnects      *prometheus.CounterVec
+	postsProcessed  *prometheus.CounterVec
+	rejected        prometheus.Counter
+	haikusFound     prometheus.Counter
+	haikusFiltered  *prometheus.CounterVec
+	processDuration *prometheus.HistogramVec
+}
+
+// New creates the pipeline's collectors, registered on a new Registry along with the go runtime and process collectors
+func New() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		tweetsReceived: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "wildhaiku_tweets_received_total",
+			Help: "Tweets read off of the twitter stream, before filtering.",
+		}),
+		tweetsDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wildhaiku_tweets_dropped_total",
+			Help: "Tweets dropped before processing, by reason: language or empty.",
+		}, []string{"reason"}),
+		retweets: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "wildhaiku_retweets_total",
+			Help: "Retweets read off of the twitter stream, which are processed as the tweet they retweet.",
+		}),
+		parseErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "wildhaiku_tweet_parse_errors_total",
+			Help: "Lines of the twitter stream that could not be decoded.",
+		}),
+		deletesDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "wildhaiku_deletions_dropped_total",
+			Help: "Deleted or withheld tweets that were not removed from the archive, as the deletion queue was full.",
+		}),
+		keepalives: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "wildhaiku_keepalives_total",
+			Help: "Keepalives sent by the twitter stream.",
+		}),
+		reconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wildhaiku_reconnects_total",
+			Help: "Reconnects to the twitter stream, by reason: network, stall, http or rate_limit.",
+		}, []string{"reason"}),
+		postsProcessed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wildhaiku_posts_processed_total",
+			Help: "Posts processed, by result: found, none, unknown_words or truncated.",
+		}, []string{"result"}),
+		rejected: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "wildhaiku_paragraphs_rejected_total",
+			Help: "Posts not searched, as words not in the corpus left too few syllables.",
+		}),
+		haikusFound: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "wildhaiku_haikus_found_total",
+			Help: "Poems of any form found in posts, before filtering.",
+		}),
+		haikusFiltered: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wildhaiku_haikus_filtered_total",
+			Help: "Found poems that were not archived, by reason: min_score or suffix.",
+		}, []string{"reason"}),
+		processDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "wildhaiku_process_duration_seconds",
+			Help:    "Time taken to process a post, by result.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 2, 16),
+		}, []string{"result"}),
+	}
+	m.Registry.MustRegister(
+		m.tweetsReceived, m.tweetsDropped, m.retweets, m.parseErrors, m.deletesDropped, m.keepalives, m.reconnects,
+		m.postsProcessed, m.rejected, m.haikusFound, m.haikusFiltered, m.processDuration,
+		collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// StreamEvent is a twitter.StreamEventFunc counting tweets, keepalives, parse errors and dropped deletions
+func (m *Metrics) StreamEvent(event twitter.StreamEvent) {
+	switch event {
+	case twitter.TweetReceived:
+		m.tweetsReceived.Inc()
+	case twitter.KeepaliveReceived:
+		m.keepalives.Inc()
+	case twitter.TweetDroppedLanguage:
+		m.tweetsDropped.WithLabelValues("language").Inc()
+	case twitter.TweetDroppedEmpty:
+		m.tweetsDropped.WithLabelValues("empty").Inc()
+	case twitter.RetweetReceived:
+		m.retweets.Inc()
+	case twitter.ParseError:
+		m.parseErrors.Inc()
+	case twitter.DeletionDropped:
+		m.deletesDropped.Inc()
+	}
+}
+
+// Reconnect is a twitter.ReconnectFunc counting reconnects by reason
+func (m *Metrics) Reconnect(event twitter.ReconnectEvent) {
+	m.reconnects.WithLabelValues(string(event.Reason)).Inc()
+}
+
+// Processed is a haiku.ProcessedFunc counting processed posts and found poems, and observing how long processing took
+func (m *Metrics) Processed(result haiku.ProcessResult, elapsed time.Duration, poems int) {
+	m.postsProcessed.WithLabelValues(string(result)).Inc()
+	m.processDuration.WithLabelValues(string(result)).Observe(elapsed.Seconds())
+	if result == haiku.UnknownWords {
+		m.rejected.Inc()
+	}
+	m.haikusFound.Add(float64(poems))
+}
+
+// Filtered is an archive.FilterFunc counting poems that were not archived by reason
+func (m *Metrics) Filtered(reason archive.FilterReason, poem syllable.Poem) {
+	m.haikusFiltered.WithLabelValues(string(reason)).Inc()
+}
+
+// WatchChannel exports the depth of a channel as wildhaiku_channel_depth, labelled with name. depth is called on every scrape, ie func() int { return len(ch) }
+func (m *Metrics) WatchChannel(name string, depth func() int) {
+	m.Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name:        "wildhaiku_channel_depth",
+		Help:        "Items waiting in a pipeline channel, by channel.",
+		ConstLabels: prometheus.Labels{"channel": name},
+	}, func() float64 {
+		return float64(depth())
+	}))
+}
+
+// Handler serves the metrics in the prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/antipasta/wildhaiku/archive"
+	"github.com/antipasta/wildhaiku/haiku"
+	"github.com/antipasta/wildhaiku/syllable"
+	"github.com/antipasta/wildhaiku/twitter"
+)
+
+func TestMetrics(t *testing.T) {
+	m := New()
+	for _, event := range []twitter.StreamEvent{twitter.TweetReceived, twitter.TweetReceived, twitter.TweetDroppedLanguage, twitter.KeepaliveReceived, twitter.ParseError, twitter.RetweetReceived, twitter.DeletionDropped} {
+		m.StreamEvent(event)
+	}
+	m.Reconnect(twitter.ReconnectEvent{Reason: twitter.ReconnectStall})
+	m.Processed(haiku.PoemsFound, 2*time.Millisecond, 2)
+	m.Processed(haiku.UnknownWords, time.Millisecond, 0)
+	m.Filtered(archive.BlacklistedSuffix, syllable.Poem{})
+	channel := make(chan int, 10)
+	channel <- 1
+	channel <- 2
+	m.WatchChannel("process", func() int { return len(channel) })
+
+	server := httptest.NewServer(m.Handler())
+	defer server.Close()
+	resp, err := server.Client().Get(server.URL + Path)
+	if err != nil {
+		t.Fatalf("Error scraping metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Error reading metrics: %v", err)
+	}
+	for _, expected := range []string{
+		"wildhaiku_tweets_received_total 2",
+		`wildhaiku_tweets_dropped_total{reason="language"} 1`,
+		"wildhaiku_keepalives_total 1",
+		"wildhaiku_tweet_parse_errors_total 1",
+		"wildhaiku_retweets_total 1",
+		"wildhaiku_deletions_dropped_total 1",
+		`wildhaiku_reconnects_total{reason="stall"} 1`,
+		`wildhaiku_posts_processed_total{result="found"} 1`,
+		"wildhaiku_paragraphs_rejected_total 1",
+		"wildhaiku_haikus_found_total 2",
+		`wildhaiku_haikus_filtered_total{reason="suffix"} 1`,
+		`wildhaiku_process_duration_seconds_count{result="found"} 1`,
+		`wildhaiku_channel_depth{channel="process"} 2`,
+		"go_goroutines",
+	} {
+		if !strings.Contains(string(body), expected+"\n") && !strings.Contains(string(body), expected+" ") {
+			t.Errorf("Expected metrics to contain %s", expected)
+		}
+	}
+}
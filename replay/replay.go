@@ -0,0 +1,195 @@
+/*Package replay contains a source that reads posts back from files or stdin, so that historical dumps can be re-mined and bugs reproduced offline
+ */
+package replay
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/antipasta/wildhaiku/config"
+	"github.com/antipasta/wildhaiku/logging"
+	"github.com/antipasta/wildhaiku/source"
+	"github.com/antipasta/wildhaiku/twitter"
+	"github.com/pkg/errors"
+)
+
+// StdinPath is the path that reads from stdin instead of a file
+const StdinPath = "-"
+
+const (
+	// TweetFormat reads each line as a tweet in the JSON format of the v1.1 twitter stream
+	TweetFormat = "tweets"
+	// TweetV2Format reads each line as a tweet in the JSON format of the v2 filtered stream
+	TweetV2Format = "tweets_v2"
+	// TextFormat reads each line as the text of a post
+	TextFormat = "text"
+)
+
+// Source reads newline delimited tweets or plain text lines from files, globs, gzip archives or stdin. Implements source.Source and source.Deleter
+type Source struct {
+	Config *config.WildHaiku
+	// Paths are files or globs to read, in order. Files ending in .gz are decompressed, and StdinPath reads Stdin
+	Paths []string
+	// Format is TweetFormat, TweetV2Format or TextFormat
+	Format string
+	// Rate paces tweets by their timestamps, as a multiplier of the speed they were originally streamed at. 0 replays as fast as possible.
+	// Plain text lines have no timestamps, so are never paced
+	Rate float64
+	// Logger is where replayed files and undecodable lines are logged, defaults to slog.Default()
+	Logger         *slog.Logger
+	Stdin          io.Reader
+	ProcessChannel chan *source.Post
+	tweets         *twitter.Streamer
+	sleep          func(context.Context, time.Duration) bool
+	lastTimestamp  time.Time
+}
+
+// NewSource returns a replay.Source reading the paths and format in config.ReplayPaths and config.ReplayFormat. Errors if the format is unknown
+func NewSource(cfg *config.WildHaiku) (*Source, error) {
+	format := cfg.ReplayFormat
+	if format == "" {
+		format = TweetFormat
+	}
+	if format != TweetFormat && format != TweetV2Format && format != TextFormat {
+		return nil, errors.Errorf("Unknown replay format %v", cfg.ReplayFormat)
+	}
+	if cfg.ReplayRate < 0 {
+		return nil, errors.Errorf("Replay rate must not be negative, got %v", cfg.ReplayRate)
+	}
+	return &Source{
+		Config:         cfg,
+		Paths:          cfg.ReplayPaths,
+		Format:         format,
+		Rate:           cfg.ReplayRate,
+		Logger:         slog.Default(),
+		Stdin:          os.Stdin,
+		ProcessChannel: make(chan *source.Post, 10000),
+		tweets:         twitter.NewParser(cfg, format == TweetV2Format),
+		sleep:          source.Sleep,
+	}, nil
+}
+
+// Posts returns the channel that replayed posts are emitted on
+func (rs *Source) Posts() <-chan *source.Post {
+	return rs.ProcessChannel
+}
+
+// Deletions returns the channel that deletes and withheld notices in replayed tweet streams are reported on
+func (rs *Source) Deletions() <-chan *source.Deletion {
+	return rs.tweets.Deletions()
+}
+
+// Run reads every path in order, or until ctx is done, then closes the Posts and Deletions channels and returns. Errors if a path cannot be read
+func (rs *Source) Run(ctx context.Context) error {
+	defer close(rs.ProcessChannel)
+	defer close(rs.tweets.DeletionChannel)
+	rs.tweets.Logger = logging.OrDefault(rs.Logger)
+	files, err := rs.expandPaths()
+	if err != nil {
+		return err
+	}
+	for _, path := range files {
+		err = rs.replayPath(ctx, path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expandPaths expands globs in Paths, errors if a path matches no files
+func (rs *Source) expandPaths() ([]string, error) {
+	files := []string{}
+	for _, path := range rs.Paths {
+		if path == StdinPath {
+			files = append(files, path)
+			continue
+		}
+		matches, err := filepath.Glob(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error expanding replay path %s", path)
+		}
+		if len(matches) == 0 {
+			return nil, errors.Errorf("No files found for replay path %s", path)
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+func (rs *Source) replayPath(ctx context.Context, path string) error {
+	if path == StdinPath {
+		return rs.ReplayReader(ctx, rs.Stdin, "stdin")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "Error opening replay file %s", path)
+	}
+	defer f.Close()
+	var reader io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gzReader, err := gzip.NewReader(f)
+		if err != nil {
+			return errors.Wrapf(err, "Error reading gzip file %s", path)
+		}
+		defer gzReader.Close()
+		reader = gzReader
+	}
+	logging.OrDefault(rs.Logger).Info("Replaying file", "path", path)
+	return rs.ReplayReader(ctx, reader, path)
+}
+
+// ReplayReader reads lines off of reader until it ends or ctx is done, and sends them to the ProcessChannel as posts. name identifies the input in the IDs of plain text posts
+func (rs *Source) ReplayReader(ctx context.Context, reader io.Reader, name string) error {
+	buf := bufio.NewReader(reader)
+	for lineNumber := 1; ctx.Err() == nil; lineNumber++ {
+		line, err := buf.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return errors.Wrapf(err, "Error reading line %d of %s", lineNumber, name)
+		}
+		trimmed := strings.TrimSpace(string(line))
+		if trimmed != "" {
+			post := rs.parseLine(trimmed, name, lineNumber)
+			if post != nil && rs.pace(ctx, post) {
+				rs.ProcessChannel <- post
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (rs *Source) parseLine(line string, name string, lineNumber int) *source.Post {
+	if rs.Format == TextFormat {
+		id := fmt.Sprintf("%s:%d", name, lineNumber)
+		return &source.Post{ID: id, Permalink: id, Text: line}
+	}
+	// lines that fail to decode are logged by the parser and skipped
+	post, _ := rs.tweets.ParsePost([]byte(line))
+	return post
+}
+
+// pace sleeps for the time between the previous post and this one, divided by Rate. Returns false if ctx is done first
+func (rs *Source) pace(ctx context.Context, post *source.Post) bool {
+	if rs.Rate <= 0 || post.Timestamp.IsZero() {
+		return true
+	}
+	previous := rs.lastTimestamp
+	if post.Timestamp.After(rs.lastTimestamp) {
+		rs.lastTimestamp = post.Timestamp
+	}
+	if previous.IsZero() || !post.Timestamp.After(previous) {
+		return true
+	}
+	return rs.sleep(ctx, time.Duration(float64(post.Timestamp.Sub(previous))/rs.Rate))
+}
@@ -0,0 +1,149 @@
+package replay
+
+import (
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/antipasta/wildhaiku/config"
+	"github.com/antipasta/wildhaiku/source"
+)
+
+func drain(s *Source) []*source.Post {
+	posts := []*source.Post{}
+	for post := range s.Posts() {
+		posts = append(posts, post)
+	}
+	return posts
+}
+
+func TestReplayTweets(t *testing.T) {
+	s, err := NewSource(&config.WildHaiku{ReplayPaths: []string{"../twitter/sample*.json"}})
+	if err != nil {
+		t.Fatalf("Error creating replay source: %v", err)
+	}
+	err = s.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Error replaying: %v", err)
+	}
+	posts := drain(s)
+	if len(posts) != 591 {
+		t.Errorf("Expected 591 english tweets replayed, got %v", len(posts))
+	}
+	for _, post := range posts {
+		if post.Timestamp.IsZero() {
+			t.Errorf("Expected replayed tweet %v to have a timestamp", post.ID)
+		}
+	}
+}
+
+func TestReplayFormats(t *testing.T) {
+	v1 := `{"id_str":"1","lang":"en","text":"an old silent pond","timestamp_ms":"1000"}` + "\n"
+	v2 := `{"data":{"id":"2","text":"a frog jumps into the pond","lang":"en","author_id":"7"},"includes":{"users":[{"id":"7","username":"basho"}]}}` + "\n"
+	// the decoder follows the replay format, not the credentials of the live stream
+	for _, cfg := range []config.WildHaiku{{}, {BearerToken: "testtoken", LookupTruncatedTweets: true}} {
+		for format, line := range map[string]string{TweetFormat: v1, TweetV2Format: v2} {
+			cfg.ReplayFormat = format
+			s, err := NewSource(&cfg)
+			if err != nil {
+				t.Fatalf("Error creating replay source: %v", err)
+			}
+			if s.tweets.Lookup != nil {
+				t.Errorf("Expected replayed tweets to never be looked up with the API")
+			}
+			go func() {
+				s.ReplayReader(context.Background(), strings.NewReader(line), "stream")
+				close(s.ProcessChannel)
+			}()
+			if posts := drain(s); len(posts) != 1 {
+				t.Errorf("Expected a %s line to be replayed with bearer token %q, got %v posts", format, cfg.BearerToken, len(posts))
+			}
+		}
+	}
+}
+
+func TestReplayText(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "wildhaiku-replay")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	gzPath := filepath.Join(tmpDir, "b.txt.gz")
+	gzFile, err := os.Create(gzPath)
+	if err != nil {
+		t.Fatalf("Error creating %v: %v", gzPath, err)
+	}
+	gzWriter := gzip.NewWriter(gzFile)
+	gzWriter.Write([]byte("a frog jumps into\n\nthe sound of water\n"))
+	gzWriter.Close()
+	gzFile.Close()
+	err = ioutil.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("an old silent pond"), 0644)
+	if err != nil {
+		t.Fatalf("Error writing text file: %v", err)
+	}
+
+	s, err := NewSource(&config.WildHaiku{ReplayPaths: []string{filepath.Join(tmpDir, "*.txt*"), StdinPath}, ReplayFormat: TextFormat})
+	if err != nil {
+		t.Fatalf("Error creating replay source: %v", err)
+	}
+	s.Stdin = strings.NewReader("from stdin\n")
+	err = s.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Error replaying: %v", err)
+	}
+	posts := drain(s)
+	texts := []string{}
+	for _, post := range posts {
+		texts = append(texts, post.Text)
+	}
+	expected := "an old silent pond|a frog jumps into|the sound of water|from stdin"
+	if strings.Join(texts, "|") != expected {
+		t.Errorf("Expected replayed lines %v, got %v", expected, strings.Join(texts, "|"))
+	}
+	if posts[2].ID != gzPath+":3" || posts[3].ID != "stdin:1" {
+		t.Errorf("Expected post IDs to hold input and line number, got %v and %v", posts[2].ID, posts[3].ID)
+	}
+
+	_, err = NewSource(&config.WildHaiku{ReplayFormat: "csv"})
+	if err == nil {
+		t.Errorf("Expected error for unknown replay format")
+	}
+	s, _ = NewSource(&config.WildHaiku{ReplayPaths: []string{filepath.Join(tmpDir, "*.missing")}})
+	if err = s.Run(context.Background()); err == nil {
+		t.Errorf("Expected error for replay path matching no files")
+	}
+}
+
+func TestReplayRate(t *testing.T) {
+	stream := `{"id_str":"1","lang":"en","text":"one","timestamp_ms":"1000"}
+{"id_str":"2","lang":"en","text":"two","timestamp_ms":"3000"}
+{"id_str":"3","lang":"en","text":"three","timestamp_ms":"2000"}
+{"id_str":"4","lang":"en","text":"four","timestamp_ms":"7000"}
+`
+	s, err := NewSource(&config.WildHaiku{ReplayRate: 2})
+	if err != nil {
+		t.Fatalf("Error creating replay source: %v", err)
+	}
+	slept := []time.Duration{}
+	s.sleep = func(ctx context.Context, d time.Duration) bool {
+		slept = append(slept, d)
+		return true
+	}
+	go func() {
+		s.ReplayReader(context.Background(), strings.NewReader(stream), "stream")
+		close(s.ProcessChannel)
+	}()
+	if posts := drain(s); len(posts) != 4 {
+		t.Errorf("Expected 4 tweets replayed, got %v", len(posts))
+	}
+	// out of order tweets are not delayed
+	expected := []time.Duration{time.Second, 2 * time.Second}
+	if len(slept) != len(expected) || slept[0] != expected[0] || slept[1] != expected[1] {
+		t.Errorf("Expected sleeps %v at double speed, got %v", expected, slept)
+	}
+}
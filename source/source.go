@@ -0,0 +1,92 @@
+/*Package source defines the generic Post and Source types that the haiku pipeline reads from, so that text can be pulled from any stream of posts
+ */
+package source
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Span is a part of a post's Text, as start and end byte offsets
+type Span struct {
+	Start int
+	End   int
+}
+
+// Post is a piece of text from any source, along with the metadata needed to attribute it
+type Post struct {
+	ID        string
+	Author    string
+	Permalink string
+	Text      string
+	Language  string
+	Timestamp time.Time
+	// Removed are spans of Text that the source knows are not words of the post, ie links and reply mentions, in order. They are removed before Text is searched
+	Removed []Span `json:"-"`
+	// Truncated is set when Text was cut short by the source, and ends mid sentence
+	Truncated bool `json:",omitempty"`
+	// Original is the source specific record the post was built from, ie a *twitter.Tweet, kept for archiving
+	Original interface{} `json:",omitempty"`
+}
+
+// Deletion reports that a post was deleted or withheld where it was published, and must no longer be displayed
+type Deletion struct {
+	ID string
+	// Reason is why the post is gone, ie delete or withheld
+	Reason string
+}
+
+// Deleter is implemented by sources that report deleted posts
+type Deleter interface {
+	// Deletions returns the channel that deletions are emitted on
+	Deletions() <-chan *Deletion
+}
+
+// Status is a point in time view of a source's connection to its stream
+type Status struct {
+	Connected bool
+	// LastMessage is when anything, keepalives included, was last read off of the stream. Zero if nothing has been read yet
+	LastMessage time.Time
+}
+
+// StatusReporter is implemented by sources that report the state of their connection
+type StatusReporter interface {
+	Status() Status
+}
+
+// Source is a stream of Posts
+type Source interface {
+	// Posts returns the channel that posts are emitted on
+	Posts() <-chan *Post
+	// Run connects to the source and emits posts on the Posts channel until ctx is done, or a source with a finite number of posts has emitted them all.
+	// The Posts channel, and Deletions channel of a Deleter, are closed when Run returns. Returns an error only if the source cannot continue
+	Run(ctx context.Context) error
+}
+
+// Sleep waits for d, returning false if ctx is done first
+func Sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// CloseOnDone closes c once ctx is done, unblocking any reads from it, unless the returned stop function is called first
+func CloseOnDone(ctx context.Context, c io.Closer) (stop func()) {
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-stopped:
+		}
+	}()
+	return func() {
+		close(stopped)
+	}
+}
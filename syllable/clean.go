@@ -0,0 +1,280 @@
+package syllable
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// truncationMark is appended by Twitter to text cut short, before a link to the full text
+const truncationMark = "…"
+
+// CleanedText is text with cruft removed by a TextCleaner, along with a map from every byte of Text back to the span of the original text it came from
+type CleanedText struct {
+	Text     string
+	Original string
+	starts   []int
+	ends     []int
+}
+
+// OriginalSpan converts a start and end byte offset in the cleaned text to offsets in the original text
+func (ct CleanedText) OriginalSpan(start int, end int) (int, int) {
+	if start >= end || start < 0 || end > len(ct.starts) {
+		return 0, 0
+	}
+	return ct.starts[start], ct.ends[end-1]
+}
+
+// RestoreOffsets returns a copy of the poem, found in the cleaned text, with word offsets moved to the original text
+func (ct CleanedText) RestoreOffsets(p Poem) Poem {
+	lines := make([]Sentence, len(p.Lines))
+	for lineIndex, line := range p.Lines {
+		lines[lineIndex] = make(Sentence, len(line))
+		for wordIndex, word := range line {
+			if word.hasOffsets() {
+				word.Start, word.End = ct.OriginalSpan(word.Start, word.End)
+			}
+			lines[lineIndex][wordIndex] = word
+		}
+	}
+	p.Lines = lines
+	return p
+}
+
+// TextCleaner removes the parts of a post that cannot be read as prose: links, reply mention prefixes, RT markers, emoji and truncation marks, so that what is left can be counted by the syllable counter
+type TextCleaner struct {
+	// KeepMentions leaves @mentions in the middle of text, ie when they are split into words by CMUCorpus.ExpandHashtag. Leading reply mentions are always removed
+	KeepMentions bool
+}
+
+// Span is a part of a text, as start and end byte offsets
+type Span struct {
+	Start int
+	End   int
+}
+
+// span is a part of the original text that is removed, acting as a space, or replaced with replacement
+type span struct {
+	start, end  int
+	replacement string
+}
+
+// withRemoved adds the removed spans, clipped to text, to spans found in text, merging any that overlap so that the result is ordered and does not overlap.
+// A replacement is dropped if its span overlaps a removed span
+func withRemoved(spans []span, removed []Span, textLength int) []span {
+	if len(removed) == 0 {
+		return spans
+	}
+	all := append([]span{}, spans...)
+	for _, r := range removed {
+		if r.End > textLength {
+			r.End = textLength
+		}
+		if r.Start < 0 {
+			r.Start = 0
+		}
+		if r.Start < r.End {
+			all = append(all, span{start: r.Start, end: r.End})
+		}
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].start < all[j].start
+	})
+	merged := []span{}
+	for _, s := range all {
+		if last := len(merged) - 1; last >= 0 && s.start < merged[last].end {
+			if s.end > merged[last].end {
+				merged[last].end = s.end
+			}
+			merged[last].replacement = ""
+			continue
+		}
+		merged = append(merged, s)
+	}
+	return merged
+}
+
+// isEmojiPart checks if a character is an emoji, or joins or modifies one
+func isEmojiPart(char rune) bool {
+	return isEmoji(char) || char == 0x200D || char == 0xFE0F || char == 0x20E3
+}
+
+func isURL(field string) bool {
+	return strings.HasPrefix(field, "http://") || strings.HasPrefix(field, "https://") || strings.HasPrefix(field, "www.")
+}
+
+func isMentionChar(char rune) bool {
+	return char == '_' || unicode.IsLetter(char) || unicode.IsDigit(char)
+}
+
+// mentionLength returns the length in bytes of the @mention at the start of text, 0 if there is none
+func mentionLength(text string) int {
+	if !strings.HasPrefix(text, "@") {
+		return 0
+	}
+	length := 1
+	for _, char := range text[1:] {
+		if !isMentionChar(char) {
+			break
+		}
+		length += utf8.RuneLen(char)
+	}
+	if length == 1 {
+		return 0
+	}
+	return length
+}
+
+// field is a run of non space characters in the original text
+type field struct {
+	start, end int
+	text       string
+}
+
+func fields(text string) []field {
+	found := []field{}
+	start := -1
+	for i, char := range text {
+		if unicode.IsSpace(char) {
+			if start >= 0 {
+				found = append(found, field{start, i, text[start:i]})
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		found = append(found, field{start, len(text), text[start:]})
+	}
+	return found
+}
+
+// isReplyPrefix checks if a field is a retweet marker or a whole @mention, which are removed from the start of text
+func isReplyPrefix(text string) bool {
+	if text == "RT" || text == "RT:" {
+		return true
+	}
+	mention := strings.TrimRight(text, ":")
+	return mentionLength(mention) == len(mention) && len(mention) > 0
+}
+
+// removedSpans finds the parts of text to remove or replace, in order
+func (tc *TextCleaner) removedSpans(text string) []span {
+	textFields := fields(text)
+	lastWord := -1
+	for fieldIndex, f := range textFields {
+		if !isURL(f.text) {
+			lastWord = fieldIndex
+		}
+	}
+	spans := []span{}
+	leading := true
+	for fieldIndex, f := range textFields {
+		if isURL(f.text) || (leading && isReplyPrefix(f.text)) {
+			spans = append(spans, span{start: f.start, end: f.end})
+			continue
+		}
+		leading = false
+		if fieldIndex == lastWord && lastWord < len(textFields)-1 && strings.HasSuffix(f.text, truncationMark) {
+			// text cut short before a trailing link, the final word is incomplete
+			spans = append(spans, span{start: f.start, end: f.end})
+			continue
+		}
+		spans = append(spans, tc.fieldSpans(f)...)
+	}
+	return spans
+}
+
+// fieldSpans finds emoji, @mentions and ellipses within a field
+func (tc *TextCleaner) fieldSpans(f field) []span {
+	spans := []span{}
+	for i := 0; i < len(f.text); {
+		char, size := utf8.DecodeRuneInString(f.text[i:])
+		offset := f.start + i
+		switch {
+		case isEmojiPart(char):
+			spans = append(spans, span{start: offset, end: offset + size})
+		case char == '@' && !tc.KeepMentions && (i == 0 || !isMentionChar(previousRune(f.text[:i]))):
+			if length := mentionLength(f.text[i:]); length > 0 {
+				spans = append(spans, span{start: offset, end: offset + length})
+				i += length
+				continue
+			}
+		case strings.HasPrefix(f.text[i:], truncationMark):
+			// an ellipsis in the text reads as ... to the tokenizer
+			spans = append(spans, span{start: offset, end: offset + size, replacement: "..."})
+		}
+		i += size
+	}
+	return spans
+}
+
+func previousRune(text string) rune {
+	char, _ := utf8.DecodeLastRuneInString(text)
+	return char
+}
+
+// Clean removes links, reply mention prefixes, RT markers, emoji and truncation marks from text, and @mentions unless KeepMentions is set.
+// Any removed spans, in order, are removed as well, ie the links and reply mentions a source has marked in the text. Removed parts act as spaces, and runs of spaces are collapsed,
+// as is a space left before punctuation by a removed part
+func (tc *TextCleaner) Clean(text string, removed ...Span) CleanedText {
+	cleaned := CleanedText{Original: text}
+	out := []byte{}
+	lastSpace := true
+	// afterRemoved is set when the last part of text was removed, and nothing has been emitted since
+	afterRemoved := false
+	emit := func(s string, start int, end int) {
+		out = append(out, s...)
+		for i := 0; i < len(s); i++ {
+			cleaned.starts = append(cleaned.starts, start)
+			cleaned.ends = append(cleaned.ends, end)
+		}
+		afterRemoved = false
+	}
+	spans := withRemoved(tc.removedSpans(text), removed, len(text))
+	for offset := 0; offset < len(text); {
+		if len(spans) > 0 && spans[0].start == offset {
+			s := spans[0]
+			spans = spans[1:]
+			offset = s.end
+			if s.replacement == "" {
+				if !lastSpace {
+					emit(" ", s.start, s.end)
+					lastSpace = true
+				}
+				afterRemoved = true
+				continue
+			}
+			emit(s.replacement, s.start, s.end)
+			lastSpace = false
+			continue
+		}
+		char, size := utf8.DecodeRuneInString(text[offset:])
+		isSpace := unicode.IsSpace(char)
+		if isSpace && lastSpace {
+			offset += size
+			continue
+		}
+		if afterRemoved && lastSpace && len(out) > 0 && strings.ContainsRune(",.;:!?", char) {
+			// thanks @bob, reads as thanks, not thanks ,
+			out = out[:len(out)-1]
+			cleaned.starts = cleaned.starts[:len(out)]
+			cleaned.ends = cleaned.ends[:len(out)]
+		}
+		for i := 0; i < size; i++ {
+			emit(text[offset+i:offset+i+1], offset+i, offset+i+1)
+		}
+		lastSpace = isSpace
+		offset += size
+	}
+	// drop trailing space
+	trimmed := strings.TrimRightFunc(string(out), unicode.IsSpace)
+	cleaned.Text = trimmed
+	cleaned.starts = cleaned.starts[:len(trimmed)]
+	cleaned.ends = cleaned.ends[:len(trimmed)]
+	return cleaned
+}
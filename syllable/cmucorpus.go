@@ -1,8 +1,10 @@
 package syllable
 
 import (
+	"fmt"
 	"html"
 	"io/ioutil"
+	"strconv"
 	"strings"
 	"unicode"
 
@@ -20,13 +22,37 @@ type TokenFilterFunc func([]prose.Token) []prose.Token
 // CMUCorpus is used for looking up syllable counts after some preprocessing of string
 type CMUCorpus struct {
 	PreProcess []PreProcessFunc
-	Dict       map[string]int
+	// Dict maps a word to the distinct syllable counts of each of its pronunciations, in dictionary order
+	Dict map[string][]int
+	// Expand verbalizes tokens such as numbers that are not found in Dict, so that they can be counted
+	Expand []TokenExpandFunc
+	// CorpusExpand verbalizes tokens like Expand, using the words of the corpus it is called with. Tried after Expand
+	CorpusExpand []CorpusExpandFunc
+	// Fallback, if set, estimates syllable counts for words not found in Dict
+	Fallback EstimateFunc
+	// Tagging enables part of speech tagging of Words, for use with a LineBreakChecker
+	Tagging bool
+	// MinSyllables is the fewest syllables worth keeping from a paragraph that is cut short by an unknown word, the size of the smallest form being searched for
+	MinSyllables int
+	corpusPath   string
+	// overlays maps words defined in overlay dictionaries to the path of the overlay that supplied them
+	overlays map[string]string
 }
 
 // Word contains a token and its corresponding syllable count
 type Word struct {
 	Word      prose.Token
 	Syllables int
+	// Alternates holds syllable counts from any alternate pronunciations of the word, other than Syllables
+	Alternates []int
+	// Estimated is true if the syllable count came from the corpus Fallback rather than the dictionary, and is less trustworthy
+	Estimated bool
+	// Start and End are the byte offsets of the word within the original text it was created from. End is 0 if the word could not be found in the original text
+	Start int
+	End   int
+	// Original is the word exactly as it appears in the original text, and Spacing is the original text between it and the previous word
+	Original string
+	Spacing  string
 }
 
 func (tf tokenizeFunc) filter(filterFuncs ...TokenFilterFunc) []prose.Token {
@@ -37,11 +63,40 @@ func (tf tokenizeFunc) filter(filterFuncs ...TokenFilterFunc) []prose.Token {
 	return tokens
 }
 
-// NewCMUCorpus Reads cmu corpus file off disk and converts it to a mapping of word to syllablecount, returning *CMUCorpus
-func NewCMUCorpus(path string) (*CMUCorpus, error) {
-	c := CMUCorpus{Dict: map[string]int{},
-		PreProcess: []PreProcessFunc{html.UnescapeString},
+// NewCMUCorpus Reads cmu corpus file off disk and converts it to a mapping of word to syllablecounts, returning *CMUCorpus.
+// Alternate pronunciations such as graphically(2) are folded into the entry for the base word.
+// Any overlayPaths are merged on top of the cmu corpus in order, each replacing the counts of words it defines in the corpus and in earlier overlays
+func NewCMUCorpus(path string, overlayPaths ...string) (*CMUCorpus, error) {
+	c := CMUCorpus{
+		PreProcess:   []PreProcessFunc{html.UnescapeString},
+		Expand:       []TokenExpandFunc{ExpandNumber},
+		MinSyllables: HaikuForm.TotalSyllables(),
+		corpusPath:   path,
+		overlays:     map[string]string{},
 	}
+	var err error
+	c.Dict, err = readDict(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, overlayPath := range overlayPaths {
+		overlay, err := readDict(overlayPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error reading overlay dictionary %v", overlayPath)
+		}
+		for word, counts := range overlay {
+			c.Dict[word] = counts
+			c.overlays[word] = overlayPath
+		}
+	}
+
+	return &c, nil
+}
+
+// readDict reads a dictionary file into a mapping of lower case word to syllable counts. Each line is either a cmu entry of a word followed by its phonemes,
+// or a simple entry of a word followed by its syllable count, ie "uwu 2". Lines starting with ;;; or # are comments
+func readDict(path string) (map[string][]int, error) {
+	dict := map[string][]int{}
 	cmuBytes, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
@@ -49,14 +104,46 @@ func NewCMUCorpus(path string) (*CMUCorpus, error) {
 	cmuStr := string(cmuBytes)
 	cmuLines := strings.Split(cmuStr, "\n")
 	for _, line := range cmuLines {
-		words := strings.Split(line, " ")
-		c.Dict[words[0]] = c.countFromPhenomes(words[1:])
+		if strings.HasPrefix(line, ";;;") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if commentIndex := strings.Index(line, " #"); commentIndex >= 0 {
+			line = line[:commentIndex]
+		}
+		words := strings.Fields(line)
+		if len(words) == 0 {
+			continue
+		}
+		word := strings.ToLower(baseWord(words[0]))
+		if len(words) == 2 {
+			if count, err := strconv.Atoi(words[1]); err == nil {
+				addCount(dict, word, count)
+				continue
+			}
+		}
+		addCount(dict, word, countFromPhenomes(words[1:]))
 	}
+	return dict, nil
+}
 
-	return &c, nil
+// baseWord strips the alternate pronunciation marker from a cmu entry, ie graphically(2) becomes graphically
+func baseWord(entry string) string {
+	if parenIndex := strings.Index(entry, "("); parenIndex > 0 && strings.HasSuffix(entry, ")") {
+		return entry[:parenIndex]
+	}
+	return entry
 }
 
-func (c *CMUCorpus) countFromPhenomes(phenomes []string) int {
+func addCount(dict map[string][]int, word string, count int) {
+	for _, existing := range dict[word] {
+		if existing == count {
+			return
+		}
+	}
+	dict[word] = append(dict[word], count)
+}
+
+func countFromPhenomes(phenomes []string) int {
 	syllables := 0
 
 	for _, phenome := range phenomes {
@@ -72,21 +159,100 @@ func (c *CMUCorpus) countFromPhenomes(phenomes []string) int {
 
 }
 
-// SyllableCount Returns syllable count of word, errors if word not found
+// SyllableCount Returns syllable count of the primary pronunciation of word, errors if word not found
 func (c *CMUCorpus) SyllableCount(word string) (int, error) {
+	counts, err := c.SyllableCounts(word)
+	if err != nil {
+		return 0, err
+	}
+	return counts[0], nil
+}
+
+// SyllableCounts Returns the distinct syllable counts of all pronunciations of word, primary pronunciation first.
+// If the word is not found, the count of its Expand verbalization is returned, or failing that the Fallback estimate. Errors if word not found and could not be expanded or estimated
+func (c *CMUCorpus) SyllableCounts(word string) ([]int, error) {
+	counts, _, err := c.lookup(word)
+	return counts, err
+}
+
+// lookup returns the syllable counts of a word, and whether they were estimated by the Fallback
+func (c *CMUCorpus) lookup(word string) ([]int, bool, error) {
+	lowerWord := strings.ToLower(word)
+	counts, exists := c.Dict[lowerWord]
+	if exists && len(counts) > 0 {
+		return counts, false, nil
+	}
+	if count, ok := c.expandedCount(word); ok {
+		return []int{count}, false, nil
+	}
+	if c.Fallback != nil {
+		if estimate, ok := c.Fallback(lowerWord); ok {
+			return []int{estimate}, true, nil
+		}
+	}
+	return nil, false, errors.Errorf("Word not found %v", lowerWord)
+}
+
+// expandedCount returns the syllable count of the first Expand or CorpusExpand verbalization of word whose words are all in the cmu corpus
+func (c *CMUCorpus) expandedCount(word string) (int, bool) {
+	for _, expand := range c.Expand {
+		if count, ok := c.countExpansion(expand(word)); ok {
+			return count, true
+		}
+	}
+	for _, expand := range c.CorpusExpand {
+		if count, ok := c.countExpansion(expand(c, word)); ok {
+			return count, true
+		}
+	}
+	return 0, false
+}
+
+// countExpansion returns the total syllable count of an expansion, if ok and all of its words are in the cmu corpus
+func (c *CMUCorpus) countExpansion(expansion []string, ok bool) (int, bool) {
+	if !ok {
+		return 0, false
+	}
+	total := 0
+	for _, expandedWord := range expansion {
+		counts, exists := c.Dict[strings.ToLower(expandedWord)]
+		if !exists || len(counts) == 0 {
+			return 0, false
+		}
+		total += counts[0]
+	}
+	return total, total > 0
+}
+
+// Source describes where the syllable count of word comes from: the cmu corpus, an overlay dictionary, a token expansion or the fallback estimate. Errors if word not found
+func (c *CMUCorpus) Source(word string) (string, error) {
 	lowerWord := strings.ToLower(word)
-	phenomes, exists := c.Dict[lowerWord]
-	if !exists {
-		return 0, errors.Errorf("Word not found %v", lowerWord)
+	if overlayPath, exists := c.overlays[lowerWord]; exists {
+		return fmt.Sprintf("overlay %s", overlayPath), nil
+	}
+	if counts, exists := c.Dict[lowerWord]; exists && len(counts) > 0 {
+		return fmt.Sprintf("corpus %s", c.corpusPath), nil
+	}
+	if _, ok := c.expandedCount(word); ok {
+		return "expansion", nil
 	}
-	return phenomes, nil
+	if c.Fallback != nil {
+		if _, ok := c.Fallback(lowerWord); ok {
+			return "estimate", nil
+		}
+	}
+	return "", errors.Errorf("Word not found %v", lowerWord)
 }
 
-// HasSyllableCount Checks if a word is in the cpu corpus and has a syllable count
+// HasSyllableCount Checks if a word is in the cpu corpus, or can be expanded into words that are, and has a syllable count. Fallback estimates are not considered
 func (c *CMUCorpus) HasSyllableCount(word string) bool {
 	lowerWord := strings.ToLower(word)
-	phenomes, exists := c.Dict[lowerWord]
-	return exists && phenomes > 0
+	counts, exists := c.Dict[lowerWord]
+	if exists && len(counts) > 0 && counts[0] > 0 {
+		return true
+	}
+	_, ok := c.expandedCount(word)
+	return ok
 }
 
 // IsSymbolOrPunct Checks if token is a non word or digit character
@@ -128,13 +294,14 @@ func (c *CMUCorpus) NewSentence(sentence string, filters ...TokenFilterFunc) (Se
 	sentenceDoc, err := prose.NewDocument(sentence,
 		prose.WithTokenization(true),
 		prose.WithExtraction(false),
-		prose.WithTagging(false),
+		prose.WithTagging(c.Tagging),
 		prose.UsingModel(nil))
 	if err != nil {
 		return nil, errors.Wrapf(err, "Error parsing new document %+v", sentence)
 	}
+	filters = append([]TokenFilterFunc{JoinCurrencySymbols}, filters...)
 	for _, v := range tokenizeFunc(sentenceDoc.Tokens).filter(filters...) {
-		count, err := c.SyllableCount(v.Text)
+		counts, estimated, err := c.lookup(v.Text)
 		if err != nil {
 			if IsSymbolOrPunct(&v) {
 				syllableSentence = append(syllableSentence, Word{Word: v, Syllables: 0})
@@ -142,7 +309,9 @@ func (c *CMUCorpus) NewSentence(sentence string, filters ...TokenFilterFunc) (Se
 			}
 			return Sentence{}, errors.Errorf("Could not find count for [%+v]", v)
 		}
-		syllableSentence = append(syllableSentence, Word{Word: v, Syllables: count})
+		// capped so that appending to Alternates cannot write into the dictionary
+		syllableSentence = append(syllableSentence, Word{Word: v, Syllables: counts[0], Alternates: counts[1:len(counts):len(counts)], Estimated: estimated})
 	}
+	syllableSentence.align(sentence)
 	return syllableSentence, nil
 }
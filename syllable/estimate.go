@@ -0,0 +1,88 @@
+package syllable
+
+import (
+	"strings"
+)
+
+// EstimateFunc is used to guess the syllable count of a word that is not in the cmu corpus. Returns false if no estimate could be made
+type EstimateFunc func(word string) (int, bool)
+
+// silentSuffixes are word endings whose final vowel group is usually not pronounced, ie "hoped" or "lakes"
+var silentSuffixes = []string{"ed", "es", "e"}
+
+// syllabicSuffixes are word endings that add a vowel group that vowel counting alone would miss or merge, ie "ria" or "ion"
+var syllabicSuffixes = []string{"ia", "ium", "ious", "ier", "iest", "eo", "ual"}
+
+func isVowel(char rune) bool {
+	return strings.ContainsRune("aeiouy", char)
+}
+
+// EstimateSyllables guesses the syllable count of a word from its vowel groups and common suffixes. Only plain alphabetic words (optionally containing apostrophes) are estimated
+func EstimateSyllables(word string) (int, bool) {
+	lowerWord := strings.ToLower(word)
+	if len(lowerWord) == 0 {
+		return 0, false
+	}
+	for _, char := range lowerWord {
+		if (char < 'a' || char > 'z') && char != '\'' {
+			return 0, false
+		}
+	}
+	lowerWord = strings.Replace(lowerWord, "'", "", -1)
+	if len(lowerWord) == 0 {
+		return 0, false
+	}
+
+	syllables := 0
+	prevVowel := false
+	for _, char := range lowerWord {
+		vowel := isVowel(char)
+		if vowel && !prevVowel {
+			syllables++
+		}
+		prevVowel = vowel
+	}
+	if syllables == 0 {
+		// Acronyms and slang like "smh" are usually spelled out
+		return len(lowerWord), true
+	}
+
+	for _, suffix := range silentSuffixes {
+		if !strings.HasSuffix(lowerWord, suffix) || len(lowerWord) <= len(suffix)+1 {
+			continue
+		}
+		stem := lowerWord[:len(lowerWord)-len(suffix)]
+		lastStemChar := rune(stem[len(stem)-1])
+		if isVowel(lastStemChar) {
+			break
+		}
+		switch suffix {
+		case "ed":
+			if lastStemChar != 't' && lastStemChar != 'd' {
+				syllables--
+			}
+		case "es":
+			if !strings.HasSuffix(stem, "s") && !strings.HasSuffix(stem, "x") && !strings.HasSuffix(stem, "z") &&
+				!strings.HasSuffix(stem, "ch") && !strings.HasSuffix(stem, "sh") && !strings.HasSuffix(stem, "g") && !strings.HasSuffix(stem, "c") {
+				syllables--
+			}
+		case "e":
+			if !(lastStemChar == 'l' && len(stem) > 1 && !isVowel(rune(stem[len(stem)-2]))) {
+				// consonant + le, as in "bottle", is still pronounced
+				syllables--
+			}
+		}
+		break
+	}
+	for _, suffix := range syllabicSuffixes {
+		if strings.HasSuffix(lowerWord, suffix) {
+			syllables++
+			break
+		}
+	}
+
+	if syllables < 1 {
+		syllables = 1
+	}
+	return syllables, true
+}
@@ -0,0 +1,58 @@
+package syllable
+
+import (
+	"strings"
+)
+
+// FormConstraintFunc is any function that takes a Poem and returns whether it is acceptable for a Form. Used for rejecting poems that fit the syllable pattern of a form but not its other rules, an example is NoEstimatedWords
+type FormConstraintFunc func(Poem) bool
+
+// Form describes a kind of poem by its name and the number of syllables on each line, plus optional constraints that found poems must satisfy
+type Form struct {
+	Name        string
+	Lines       []int
+	Constraints []FormConstraintFunc
+}
+
+// builtinForms are forms that can be looked up by name with BuiltinForm
+var builtinForms = map[string][]int{
+	"haiku":    {5, 7, 5},
+	"tanka":    {5, 7, 5, 7, 7},
+	"cinquain": {2, 4, 6, 8, 2},
+}
+
+// HaikuForm is the 5-7-5 form searched for by default
+var HaikuForm = Form{Name: "haiku", Lines: builtinForms["haiku"]}
+
+// BuiltinForm returns the built in form with the given name, one of haiku, tanka or cinquain. Returns false if no such form exists
+func BuiltinForm(name string) (Form, bool) {
+	lines, exists := builtinForms[strings.ToLower(name)]
+	if !exists {
+		return Form{}, false
+	}
+	return Form{Name: strings.ToLower(name), Lines: append([]int{}, lines...)}, true
+}
+
+// TotalSyllables returns the number of syllables in a poem of this form
+func (f Form) TotalSyllables() int {
+	total := 0
+	for _, lineSize := range f.Lines {
+		total += lineSize
+	}
+	return total
+}
+
+// Accepts checks a poem against all of the form's constraints
+func (f Form) Accepts(p Poem) bool {
+	for _, constraint := range f.Constraints {
+		if !constraint(p) {
+			return false
+		}
+	}
+	return true
+}
+
+// NoEstimatedWords is a FormConstraintFunc that rejects poems containing words with estimated syllable counts
+func NoEstimatedWords(p Poem) bool {
+	return !p.HasEstimatedWords()
+}
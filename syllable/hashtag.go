@@ -0,0 +1,136 @@
+package syllable
+
+import (
+	"strings"
+	"unicode"
+)
+
+// maxSegmentLength is the longest hashtag piece that will be segmented against the dictionary, longer pieces are almost always junk
+const maxSegmentLength = 40
+
+// maxSpelledLength is the longest all caps piece that will be spelled out letter by letter if it is not a dictionary word, ie FC or NBA
+const maxSpelledLength = 4
+
+// apostropheSuffixes are contraction endings that are often typed without an apostrophe in hashtags, ie Dont or Youre
+var apostropheSuffixes = []string{"nt", "s", "re", "ll", "ve", "d", "m"}
+
+// splitCamelCase splits a hashtag or mention body on CamelCase, letter/digit and underscore boundaries, ie FCBarcelona2019 becomes FC Barcelona 2019
+func splitCamelCase(text string) []string {
+	pieces := []string{}
+	runes := []rune(text)
+	start := 0
+	for i := 1; i <= len(runes); i++ {
+		if i < len(runes) && runes[i] == '_' {
+			if i > start {
+				pieces = append(pieces, string(runes[start:i]))
+			}
+			start = i + 1
+			continue
+		}
+		if i == len(runes) {
+			if i > start {
+				pieces = append(pieces, string(runes[start:i]))
+			}
+			break
+		}
+		if i == start {
+			continue
+		}
+		prev, cur := runes[i-1], runes[i]
+		lowerToUpper := unicode.IsLower(prev) && unicode.IsUpper(cur)
+		acronymEnd := unicode.IsUpper(prev) && unicode.IsUpper(cur) && i+1 < len(runes) && unicode.IsLower(runes[i+1])
+		digitBoundary := unicode.IsDigit(prev) != unicode.IsDigit(cur)
+		if lowerToUpper || acronymEnd || digitBoundary {
+			pieces = append(pieces, string(runes[start:i]))
+			start = i
+		}
+	}
+	return pieces
+}
+
+// dictWord returns the dictionary spelling of a lower case word, allowing for a missing contraction apostrophe
+func (c *CMUCorpus) dictWord(word string) (string, bool) {
+	if counts, exists := c.Dict[word]; exists && len(counts) > 0 && counts[0] > 0 {
+		return word, true
+	}
+	for _, suffix := range apostropheSuffixes {
+		if len(word) > len(suffix) && strings.HasSuffix(word, suffix) {
+			contraction := word[:len(word)-len(suffix)] + "'" + suffix
+			if suffix == "nt" {
+				contraction = word[:len(word)-len(suffix)] + "n't"
+			}
+			if _, exists := c.Dict[contraction]; exists {
+				return contraction, true
+			}
+		}
+	}
+	return "", false
+}
+
+// segment splits a lower case string with no spaces into the fewest dictionary words, ie honeybadger becomes honey badger
+func (c *CMUCorpus) segment(text string) ([]string, bool) {
+	if len(text) > maxSegmentLength {
+		return nil, false
+	}
+	// best[i] holds the fewest words that make up text[:i], nil if text[:i] cannot be segmented
+	best := make([][]string, len(text)+1)
+	best[0] = []string{}
+	for end := 1; end <= len(text); end++ {
+		for start := 0; start < end; start++ {
+			if best[start] == nil {
+				continue
+			}
+			candidate := text[start:end]
+			if len(candidate) == 1 && candidate != "a" && candidate != "i" {
+				// lone letters make almost any string segmentable
+				continue
+			}
+			word, ok := c.dictWord(candidate)
+			if !ok {
+				continue
+			}
+			if best[end] == nil || len(best[start])+1 < len(best[end]) {
+				best[end] = append(append([]string{}, best[start]...), word)
+			}
+		}
+	}
+	if best[len(text)] == nil {
+		return nil, false
+	}
+	return best[len(text)], true
+}
+
+// ExpandHashtag splits a #hashtag or @mention into its component dictionary words for syllable counting, ie #HoneyBadgerDontCare becomes "honey badger don't care".
+// CamelCase boundaries are used first, and any piece not found in the dictionary is segmented against it. Short all caps pieces that are not words are spelled out
+func (c *CMUCorpus) ExpandHashtag(token string) ([]string, bool) {
+	if len(token) < 2 || (token[0] != '#' && token[0] != '@') {
+		return nil, false
+	}
+	words := []string{}
+	for _, piece := range splitCamelCase(token[1:]) {
+		lowerPiece := strings.ToLower(piece)
+		if word, ok := c.dictWord(lowerPiece); ok {
+			words = append(words, word)
+			continue
+		}
+		if numberWords, ok := ExpandNumber(piece); ok {
+			words = append(words, numberWords...)
+			continue
+		}
+		if strings.ToUpper(piece) == piece && len(piece) <= maxSpelledLength {
+			for _, letter := range lowerPiece {
+				words = append(words, string(letter))
+			}
+			continue
+		}
+		segmented, ok := c.segment(lowerPiece)
+		if !ok {
+			return nil, false
+		}
+		words = append(words, segmented...)
+	}
+	if len(words) == 0 {
+		return nil, false
+	}
+	return words, true
+}
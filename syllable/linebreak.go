@@ -0,0 +1,135 @@
+package syllable
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// LineBreakRule sets how a bad line break, found using part of speech tags, is treated at one line position
+type LineBreakRule int
+
+const (
+	// AllowBreak ignores bad line breaks
+	AllowBreak LineBreakRule = iota
+	// PenalizeBreak lowers the poem's LineBreaks score for a bad line break
+	PenalizeBreak
+	// RejectBreak rejects the poem for a bad line break
+	RejectBreak
+)
+
+var lineBreakRuleNames = map[string]LineBreakRule{
+	"allow":    AllowBreak,
+	"penalize": PenalizeBreak,
+	"reject":   RejectBreak,
+}
+
+// ParseLineBreakRule converts one of allow, penalize or reject into a LineBreakRule. Errors if name is not a known rule
+func ParseLineBreakRule(name string) (LineBreakRule, error) {
+	rule, exists := lineBreakRuleNames[strings.ToLower(name)]
+	if !exists {
+		return AllowBreak, errors.Errorf("Unknown line break rule %v", name)
+	}
+	return rule, nil
+}
+
+// closedClassTags are Penn Treebank tags of words that should not end a line: determiners, prepositions, conjunctions, possessives and modals
+var closedClassTags = map[string]bool{
+	"DT":   true,
+	"PDT":  true,
+	"IN":   true,
+	"CC":   true,
+	"TO":   true,
+	"PRP$": true,
+	"WDT":  true,
+	"WP$":  true,
+	"MD":   true,
+	"POS":  true,
+	"EX":   true,
+}
+
+// auxiliaryWords are verbs that dangle when they end a line, as in "she has"
+var auxiliaryWords = map[string]bool{
+	"am": true, "is": true, "are": true, "was": true, "were": true, "be": true, "been": true,
+	"has": true, "have": true, "had": true, "do": true, "does": true, "did": true,
+}
+
+func isNounTag(tag string) bool {
+	return strings.HasPrefix(tag, "NN")
+}
+
+// LineBreakChecker uses part of speech tags to find lines of a Poem that end on a closed class word or dangling auxiliary, or that split a compound noun across lines.
+// Words must have been tagged, see CMUCorpus.Tagging
+type LineBreakChecker struct {
+	// Rules holds the rule for each line position, the final rule applies to any further lines. No rules allows everything
+	Rules []LineBreakRule
+}
+
+// rule returns the rule for a line position
+func (lc *LineBreakChecker) rule(lineIndex int) LineBreakRule {
+	if len(lc.Rules) == 0 {
+		return AllowBreak
+	}
+	if lineIndex >= len(lc.Rules) {
+		return lc.Rules[len(lc.Rules)-1]
+	}
+	return lc.Rules[lineIndex]
+}
+
+// firstWord returns the first word in a line that has syllables, and whether punctuation comes before it
+func firstWord(line Sentence) (*Word, bool) {
+	startsOnPunct := false
+	for i := range line {
+		if line[i].Syllables > 0 {
+			return &line[i], startsOnPunct
+		}
+		if IsSymbolOrPunct(&line[i].Word) {
+			startsOnPunct = true
+		}
+	}
+	return nil, startsOnPunct
+}
+
+// BadBreaks returns the indexes of lines of the poem whose break is bad according to their tags
+func (lc *LineBreakChecker) BadBreaks(p Poem) []int {
+	bad := []int{}
+	for lineIndex, line := range p.Lines {
+		word, endsOnPunct := lastWord(line)
+		if word == nil || endsOnPunct {
+			continue
+		}
+		if closedClassTags[word.Word.Tag] || (strings.HasPrefix(word.Word.Tag, "VB") && auxiliaryWords[strings.ToLower(word.Word.Text)]) {
+			bad = append(bad, lineIndex)
+			continue
+		}
+		if lineIndex+1 < len(p.Lines) && isNounTag(word.Word.Tag) {
+			nextWord, startsOnPunct := firstWord(p.Lines[lineIndex+1])
+			if nextWord != nil && !startsOnPunct && isNounTag(nextWord.Word.Tag) {
+				// compound noun split across lines
+				bad = append(bad, lineIndex)
+			}
+		}
+	}
+	return bad
+}
+
+// Accepts is a FormConstraintFunc that rejects poems with a bad break on any line whose rule is RejectBreak
+func (lc *LineBreakChecker) Accepts(p Poem) bool {
+	for _, lineIndex := range lc.BadBreaks(p) {
+		if lc.rule(lineIndex) == RejectBreak {
+			return false
+		}
+	}
+	return true
+}
+
+// Penalized returns the indexes of lines of the poem with a bad break whose rule is PenalizeBreak
+func (lc *LineBreakChecker) Penalized(p Poem) []int {
+	penalized := []int{}
+	for _, lineIndex := range lc.BadBreaks(p) {
+		if lc.rule(lineIndex) == PenalizeBreak {
+			penalized = append(penalized, lineIndex)
+		}
+	}
+	return penalized
+}
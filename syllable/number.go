@@ -0,0 +1,270 @@
+package syllable
+
+import (
+	"strconv"
+	"strings"
+
+	prose "gopkg.in/antipasta/prose.v2"
+)
+
+// TokenExpandFunc verbalizes a token into words that can be looked up in the cmu corpus, ie "64th" becomes "sixty fourth". Expansions are only used for counting syllables, the original token is kept for output. Returns false if the token is not handled
+type TokenExpandFunc func(token string) ([]string, bool)
+
+// CorpusExpandFunc is a TokenExpandFunc that looks up words in the corpus it is called with, ie (*CMUCorpus).ExpandHashtag.
+// A CorpusSnapshot calls it with its own copy of the corpus, so that it is not affected by later changes to the corpus it was taken from
+type CorpusExpandFunc func(c *CMUCorpus, token string) ([]string, bool)
+
+var smallNumbers = []string{"zero", "one", "two", "three", "four", "five", "six", "seven", "eight", "nine", "ten",
+	"eleven", "twelve", "thirteen", "fourteen", "fifteen", "sixteen", "seventeen", "eighteen", "nineteen"}
+var tensNumbers = []string{"", "", "twenty", "thirty", "forty", "fifty", "sixty", "seventy", "eighty", "ninety"}
+var scaleNumbers = []struct {
+	value int64
+	name  string
+}{
+	{1000000000000, "trillion"},
+	{1000000000, "billion"},
+	{1000000, "million"},
+	{1000, "thousand"},
+	{100, "hundred"},
+}
+
+var ordinalWords = map[string]string{
+	"one":      "first",
+	"two":      "second",
+	"three":    "third",
+	"five":     "fifth",
+	"eight":    "eighth",
+	"nine":     "ninth",
+	"twelve":   "twelfth",
+	"twenty":   "twentieth",
+	"thirty":   "thirtieth",
+	"forty":    "fortieth",
+	"fifty":    "fiftieth",
+	"sixty":    "sixtieth",
+	"seventy":  "seventieth",
+	"eighty":   "eightieth",
+	"ninety":   "ninetieth",
+	"hundred":  "hundredth",
+	"thousand": "thousandth",
+	"million":  "millionth",
+	"billion":  "billionth",
+	"trillion": "trillionth",
+}
+
+var currencySymbols = map[string]string{
+	"$": "dollars",
+	"£": "pounds",
+	"€": "euros",
+}
+
+var ordinalSuffixes = []string{"st", "nd", "rd", "th"}
+
+// cardinal verbalizes a non negative integer, ie 342 becomes "three hundred forty two"
+func cardinal(n int64) []string {
+	if n < 20 {
+		return []string{smallNumbers[n]}
+	}
+	if n < 100 {
+		words := []string{tensNumbers[n/10]}
+		if n%10 > 0 {
+			words = append(words, smallNumbers[n%10])
+		}
+		return words
+	}
+	for _, scale := range scaleNumbers {
+		if n < scale.value {
+			continue
+		}
+		words := append(cardinal(n/scale.value), scale.name)
+		if n%scale.value > 0 {
+			words = append(words, cardinal(n%scale.value)...)
+		}
+		return words
+	}
+	return nil
+}
+
+// ordinal verbalizes a non negative integer as an ordinal, ie 64 becomes "sixty fourth"
+func ordinal(n int64) []string {
+	words := cardinal(n)
+	last := words[len(words)-1]
+	if ordinalWord, exists := ordinalWords[last]; exists {
+		words[len(words)-1] = ordinalWord
+	} else {
+		words[len(words)-1] = last + "th"
+	}
+	return words
+}
+
+// year verbalizes a four digit number the way years are usually read aloud, ie 1999 becomes "nineteen ninety nine"
+func year(n int64) []string {
+	century, remainder := n/100, n%100
+	if n >= 2000 && n < 2010 || n%1000 == 0 {
+		return cardinal(n)
+	}
+	if remainder == 0 {
+		return append(cardinal(century), "hundred")
+	}
+	if remainder < 10 {
+		return append(cardinal(century), "oh", smallNumbers[remainder])
+	}
+	return append(cardinal(century), cardinal(remainder)...)
+}
+
+func isDigits(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for _, char := range s {
+		if char < '0' || char > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// parseDigits parses a string of digits, allowing comma thousands separators
+func parseDigits(s string) (int64, bool) {
+	s = strings.Replace(s, ",", "", -1)
+	if !isDigits(s) || len(s) > 15 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n, err == nil
+}
+
+// digitsByDigit verbalizes a string of digits one at a time, as in the fractional part of "3.14"
+func digitsByDigit(s string) []string {
+	words := []string{}
+	for _, char := range s {
+		words = append(words, smallNumbers[char-'0'])
+	}
+	return words
+}
+
+// expandQuantity verbalizes a plain number, allowing comma separators and a decimal part
+func expandQuantity(token string) ([]string, bool) {
+	parts := strings.SplitN(token, ".", 2)
+	n, ok := parseDigits(parts[0])
+	if !ok {
+		return nil, false
+	}
+	words := cardinal(n)
+	if len(parts) == 2 {
+		if !isDigits(parts[1]) {
+			return nil, false
+		}
+		words = append(append(words, "point"), digitsByDigit(parts[1])...)
+	}
+	return words, true
+}
+
+// expandTime verbalizes a clock time such as 3:30, 3:05pm or 12:00
+func expandTime(token string) ([]string, bool) {
+	meridiem := []string{}
+	lowerToken := strings.ToLower(token)
+	for _, suffix := range []string{"am", "pm"} {
+		if strings.HasSuffix(lowerToken, suffix) {
+			meridiem = []string{suffix[:1], "m"}
+			lowerToken = lowerToken[:len(lowerToken)-len(suffix)]
+			break
+		}
+	}
+	if !strings.Contains(lowerToken, ":") {
+		if len(meridiem) == 0 || !isDigits(lowerToken) || len(lowerToken) > 2 {
+			return nil, false
+		}
+		// 5pm
+		hour, _ := strconv.ParseInt(lowerToken, 10, 64)
+		return append(cardinal(hour), meridiem...), true
+	}
+	parts := strings.SplitN(lowerToken, ":", 2)
+	if !isDigits(parts[0]) || len(parts[0]) > 2 || !isDigits(parts[1]) || len(parts[1]) != 2 {
+		return nil, false
+	}
+	hour, _ := strconv.ParseInt(parts[0], 10, 64)
+	minute, _ := strconv.ParseInt(parts[1], 10, 64)
+	words := cardinal(hour)
+	switch {
+	case minute == 0 && len(meridiem) == 0:
+		words = append(words, "o'clock")
+	case minute == 0:
+	case minute < 10:
+		words = append(words, "oh", smallNumbers[minute])
+	default:
+		words = append(words, cardinal(minute)...)
+	}
+	return append(words, meridiem...), true
+}
+
+// ExpandNumber verbalizes numbers, years, ordinals, clock times, percentages and currency amounts, ie "$5" becomes "five dollars" and "2019" becomes "twenty nineteen"
+func ExpandNumber(token string) ([]string, bool) {
+	if len(token) == 0 {
+		return nil, false
+	}
+	for symbol, name := range currencySymbols {
+		if strings.HasPrefix(token, symbol) {
+			amount := strings.TrimPrefix(token, symbol)
+			parts := strings.SplitN(amount, ".", 2)
+			words, ok := expandQuantity(parts[0])
+			if !ok {
+				return nil, false
+			}
+			words = append(words, name)
+			if len(parts) == 2 {
+				cents, ok := parseDigits(parts[1])
+				if !ok || len(parts[1]) != 2 {
+					return nil, false
+				}
+				if cents > 0 {
+					words = append(append(words, "and"), cardinal(cents)...)
+					words = append(words, "cents")
+				}
+			}
+			return words, true
+		}
+	}
+	if strings.HasSuffix(token, "%") {
+		words, ok := expandQuantity(strings.TrimSuffix(token, "%"))
+		if !ok {
+			return nil, false
+		}
+		return append(words, "percent"), true
+	}
+	if words, ok := expandTime(token); ok {
+		return words, true
+	}
+	lowerToken := strings.ToLower(token)
+	for _, suffix := range ordinalSuffixes {
+		if strings.HasSuffix(lowerToken, suffix) {
+			n, ok := parseDigits(strings.TrimSuffix(lowerToken, suffix))
+			if !ok {
+				return nil, false
+			}
+			return ordinal(n), true
+		}
+	}
+	if isDigits(token) && len(token) == 4 && token[0] != '0' {
+		n, _ := strconv.ParseInt(token, 10, 64)
+		if n >= 1100 && n < 2100 {
+			return year(n), true
+		}
+	}
+	return expandQuantity(token)
+}
+
+// JoinCurrencySymbols merges a currency symbol token with the amount that follows it, so that "$" "5" can be expanded as "$5"
+func JoinCurrencySymbols(tokens []prose.Token) []prose.Token {
+	joined := []prose.Token{}
+	for i := 0; i < len(tokens); i++ {
+		if _, isCurrency := currencySymbols[tokens[i].Text]; isCurrency && i+1 < len(tokens) && len(tokens[i+1].Text) > 0 && isDigits(tokens[i+1].Text[:1]) {
+			joinedToken := tokens[i+1]
+			joinedToken.Text = tokens[i].Text + tokens[i+1].Text
+			joined = append(joined, joinedToken)
+			i++
+			continue
+		}
+		joined = append(joined, tokens[i])
+	}
+	return joined
+}
@@ -0,0 +1,131 @@
+package syllable
+
+import (
+	"bytes"
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxEntityLength is the longest html entity, ie &rsquo;, that is matched against a token character
+const maxEntityLength = 10
+
+// equivalentRunes are characters that the tokenizer normalizes, mapped to what they are normalized to
+var equivalentRunes = map[rune]rune{
+	'‘': '\'',
+	'’': '\'',
+	'“': '"',
+	'”': '"',
+}
+
+// matchAt checks if token appears in text starting at byte offset start, allowing for html entities and normalized quotes in text. Returns the end offset of the match
+func matchAt(text string, start int, token string) (int, bool) {
+	pos := start
+	for _, tokenChar := range token {
+		if pos >= len(text) {
+			return 0, false
+		}
+		textChar, size := utf8.DecodeRuneInString(text[pos:])
+		if textChar == tokenChar || equivalentRunes[textChar] == tokenChar {
+			pos += size
+			continue
+		}
+		if textChar == '&' {
+			if entityEnd := strings.IndexByte(text[pos:], ';'); entityEnd > 0 && entityEnd < maxEntityLength {
+				decoded := []rune(html.UnescapeString(text[pos : pos+entityEnd+1]))
+				if len(decoded) == 1 && (decoded[0] == tokenChar || equivalentRunes[decoded[0]] == tokenChar) {
+					pos += entityEnd + 1
+					continue
+				}
+			}
+		}
+		return 0, false
+	}
+	return pos, true
+}
+
+// findToken finds the first occurrence of token in text at or after byte offset from, returning its start and end offsets
+func findToken(text string, from int, token string) (int, int, bool) {
+	for from < len(text) {
+		char, size := utf8.DecodeRuneInString(text[from:])
+		if !unicode.IsSpace(char) {
+			break
+		}
+		from += size
+	}
+	for start := from; start < len(text); start++ {
+		if !utf8.RuneStart(text[start]) {
+			continue
+		}
+		if end, ok := matchAt(text, start, token); ok {
+			return start, end, true
+		}
+	}
+	return 0, 0, false
+}
+
+// alignWords sets the byte offsets, Original text and Spacing of each word by matching the words in order against text, which they were created from.
+// Words that cannot be matched are left without offsets
+func alignWords(words []*Word, text string) {
+	cursor := 0
+	for _, word := range words {
+		start, end, ok := findToken(text, cursor, word.Word.Text)
+		if !ok {
+			word.Start, word.End, word.Original, word.Spacing = 0, 0, "", ""
+			continue
+		}
+		word.Start = start
+		word.End = end
+		word.Original = text[start:end]
+		word.Spacing = text[cursor:start]
+		cursor = end
+	}
+}
+
+// align sets the byte offsets of every word in the sentence relative to text, the original text the sentence was created from
+func (s Sentence) align(text string) {
+	words := make([]*Word, len(s))
+	for i := range s {
+		words[i] = &s[i]
+	}
+	alignWords(words, text)
+}
+
+// align sets the byte offsets of every word in the paragraph relative to text, the original text the paragraph was created from
+func (p Paragraph) align(text string) {
+	words := []*Word{}
+	for _, sentence := range p {
+		for i := range sentence {
+			words = append(words, &sentence[i])
+		}
+	}
+	alignWords(words, text)
+}
+
+// hasOffsets checks if the word was matched against the original text
+func (w Word) hasOffsets() bool {
+	return w.End > w.Start
+}
+
+// hasOffsets checks if every word in the sentence was matched against the original text
+func (s Sentence) hasOffsets() bool {
+	for _, word := range s {
+		if !word.hasOffsets() {
+			return false
+		}
+	}
+	return len(s) > 0
+}
+
+// originalText reproduces the sentence exactly as it appears in the original text, with any html entities unescaped
+func (s Sentence) originalText() string {
+	text := bytes.Buffer{}
+	for i, word := range s {
+		if i > 0 {
+			text.WriteString(word.Spacing)
+		}
+		text.WriteString(word.Original)
+	}
+	return html.UnescapeString(text.String())
+}
@@ -3,32 +3,53 @@
 package syllable
 
 import (
-	"fmt"
-
 	"github.com/pkg/errors"
 	prose "gopkg.in/antipasta/prose.v2"
 )
 
-// Paragraph is a slice of Sentences, used to process an entire tweet looking for Haikus
+// Paragraph is a slice of Sentences, used to process an entire tweet looking for Poems
 type Paragraph []Sentence
 
-// Subdivide attempts to look for haikus, starting from each Sentence in the paragraph slice, and proceeding til end of all further sentences in the slice.
-func (p Paragraph) Subdivide(sylSizes ...int) []Haiku {
+// Subdivide attempts to look for poems with lines of sylSizes syllables, starting from each Sentence in the paragraph slice, and proceeding til end of all further sentences in the slice.
+func (p Paragraph) Subdivide(sylSizes ...int) []Poem {
+	return p.Search(SentenceStarts, sylSizes...)
+}
 
-	haikuMap := map[string]Haiku{}
-	haikus := []Haiku{}
-	for i := range p {
-		haiku := p[i:].toCombinedSentence().Subdivide(sylSizes...)
-		haikuStr := fmt.Sprintf("%s", haiku)
-		if len(haiku) > 0 {
-			if _, exists := haikuMap[haikuStr]; !exists {
-				haikuMap[haikuStr] = haiku
-				haikus = append(haikus, haiku)
+// Search attempts to look for poems with lines of sylSizes syllables, starting from each word chosen by mode, and proceeding til end of all further sentences in the slice.
+// Found poems carry their word span within the paragraph, and duplicate poems are dropped
+func (p Paragraph) Search(mode SearchMode, sylSizes ...int) []Poem {
+
+	poemMap := map[string]Poem{}
+	poems := []Poem{}
+	combined := p.toCombinedSentence()
+	for _, start := range p.startIndexes(mode) {
+		poem := combined[start:].Subdivide(sylSizes...)
+		poemStr := poem.String()
+		if len(poem.Lines) > 0 {
+			if _, exists := poemMap[poemStr]; !exists {
+				poem.Start += start
+				poem.End += start
+				poemMap[poemStr] = poem
+				poems = append(poems, poem)
 			}
 		}
 	}
-	return haikus
+	return poems
 }
+
+// FindForm looks for poems of the given form in the paragraph using the search mode, returning those that satisfy all of the form's constraints labelled with the form's name
+func (p Paragraph) FindForm(form Form, mode SearchMode) []Poem {
+	found := []Poem{}
+	for _, poem := range p.Search(mode, form.Lines...) {
+		if !form.Accepts(poem) {
+			continue
+		}
+		poem.Form = form.Name
+		found = append(found, poem)
+	}
+	return found
+}
+
 func (p Paragraph) toCombinedSentence() Sentence {
 	combinedSentence := Sentence{}
 	for i := range p {
@@ -47,8 +68,9 @@ func (p Paragraph) TotalSyllables() int {
 	return total
 }
 
-// NewParagraph takes a string as input, runs PreProcess functions on it, and then converts it to a Paragraph(slice of Sentences)
+// NewParagraph takes a string as input, runs PreProcess functions on it, and then converts it to a Paragraph(slice of Sentences). The byte offsets of each Word are relative to the input string, before PreProcess
 func (c *CMUCorpus) NewParagraph(sentence string) (Paragraph, error) {
+	original := sentence
 	for _, pFunc := range c.PreProcess {
 		sentence = pFunc(sentence)
 	}
@@ -74,15 +96,17 @@ func (c *CMUCorpus) NewParagraph(sentence string) (Paragraph, error) {
 		if err != nil {
 			// Got an error mid sentence after filtering, bail
 			//log.Printf("Got error when parsing sentence syllables %v", err)
-			if paragraph.TotalSyllables() >= 17 {
-				// Without this sentence we have more than enough to attempt to find a haiku, return what we found so far
+			if paragraph.TotalSyllables() >= c.MinSyllables {
+				// Without this sentence we have more than enough to attempt to find a poem, return what we found so far
+				paragraph.align(original)
 				return paragraph, nil
 			}
-			// we didnt get enough for a potential hiaku, bail
+			// we didnt get enough for a potential poem, bail
 			return Paragraph{}, errors.Wrapf(err, "Could not form haiku from given input")
 		}
 		paragraph = append(paragraph, sentenceObj)
 	}
+	paragraph.align(original)
 	return paragraph, nil
 
 }
@@ -0,0 +1,106 @@
+package syllable
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// Poem is a slice of Sentences, one per line, found within some text, along with the name of the Form it was found by. Has some additional functionality for formatting Poems for output
+type Poem struct {
+	Form  string
+	Lines []Sentence
+	// Start and End are the span of words, end exclusive, that the poem covers within the Sentence or Paragraph it was found in
+	Start int
+	End   int
+	// Score is the poem's quality rating, if it has been scored
+	Score *Score
+}
+
+// Haiku is a Poem of 5-7-5 syllable lines
+type Haiku = Poem
+
+// MarshalJSON satisfies the Marshaler interface, to JSONify a Poem as its form name, lines using ToStringSlice(), byte offsets of each line within the original text, and score
+func (p Poem) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Form    string
+		Lines   []string
+		Offsets [][2]int `json:",omitempty"`
+		Score   *Score   `json:",omitempty"`
+	}{Form: p.Form, Lines: p.ToStringSlice(), Offsets: p.Offsets(), Score: p.Score})
+}
+
+// ToStringSlice returns a string output of the poem, where each item in the slice is a line of the poem.
+// Lines are reproduced exactly from the original text when the offsets of all their words are known, otherwise they are rebuilt from the word tokens
+func (p Poem) ToStringSlice() []string {
+	poemLines := make([]string, len(p.Lines))
+	for lineIndex, line := range p.Lines {
+		if line.hasOffsets() {
+			poemLines[lineIndex] = line.originalText()
+			continue
+		}
+		poemLine := bytes.Buffer{}
+		for wordIndex := range line {
+			if line[wordIndex].Syllables == 0 && IsSymbolOrPunct(&line[wordIndex].Word) {
+				poemLine.WriteString(line[wordIndex].Word.Text)
+				continue
+			}
+			if wordIndex > 0 && wordIndex < len(line) {
+				// Works in most cases, original text is used when available for proper spacing for quotes
+				poemLine.WriteString(" ")
+			}
+			poemLine.WriteString(line[wordIndex].Word.Text)
+
+		}
+		poemLines[lineIndex] = poemLine.String()
+	}
+	return poemLines
+}
+
+// Offsets returns the start and end byte offsets of each line of the poem within the original text, or nil if any are unknown
+func (p Poem) Offsets() [][2]int {
+	offsets := [][2]int{}
+	for _, line := range p.Lines {
+		if len(line) == 0 || !line.hasOffsets() {
+			return nil
+		}
+		offsets = append(offsets, [2]int{line[0].Start, line[len(line)-1].End})
+	}
+	return offsets
+}
+
+// ToStringArray returns a string output of a haiku, where each item in the array[3] is a line of the haiku. Lines past the third are dropped
+func (p Poem) ToStringArray() [3]string {
+	haikuLines := [3]string{}
+	copy(haikuLines[:], p.ToStringSlice())
+	return haikuLines
+}
+
+// String stringifies the poem for output
+func (p Poem) String() string {
+	return strings.Join(p.ToStringSlice(), "\n")
+}
+
+// FinalWord returns the final word of the poem, or an empty string if its final line is empty. Used for filtering poems that end in a 'dangling' word
+func (p Poem) FinalWord() string {
+	if len(p.Lines) == 0 {
+		return ""
+	}
+	finalLine := p.Lines[len(p.Lines)-1]
+	if len(finalLine) == 0 {
+		return ""
+	}
+	return finalLine[len(finalLine)-1].Word.Text
+}
+
+// HasEstimatedWords returns true if any word in the poem had its syllable count estimated rather than looked up in the cmu corpus
+func (p Poem) HasEstimatedWords() bool {
+	for _, line := range p.Lines {
+		for _, word := range line {
+			if word.Estimated {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+package syllable
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Score rates a Poem on several measures of quality, each between 0 and 1 where higher is better, along with their weighted Total
+type Score struct {
+	// LineBreaks rates whether lines end on punctuation or a phrase boundary
+	LineBreaks float64
+	// Coverage is the share of the source text's words that are in the poem
+	Coverage float64
+	// StopWords is one minus the share of the poem's words that are stop words
+	StopWords float64
+	// Clean is lowered if URLs or emoji had to be stripped from the source text
+	Clean float64
+	// Endings is the share of lines that do not end on a dangling function word
+	Endings float64
+	Total   float64
+}
+
+// ScoreWeights sets how much each measure contributes to a Score's Total
+type ScoreWeights struct {
+	LineBreaks float64
+	Coverage   float64
+	StopWords  float64
+	Clean      float64
+	Endings    float64
+}
+
+// DefaultScoreWeights favors poems with natural line breaks and no dangling words
+var DefaultScoreWeights = ScoreWeights{LineBreaks: 3, Coverage: 1, StopWords: 1, Clean: 1, Endings: 3}
+
+// stopWords are common words that carry little meaning on their own
+var stopWords = map[string]bool{
+	"a": true, "an": true, "the": true, "and": true, "or": true, "but": true, "if": true, "of": true, "to": true, "in": true,
+	"on": true, "at": true, "by": true, "for": true, "with": true, "from": true, "as": true, "is": true, "are": true, "was": true,
+	"were": true, "be": true, "been": true, "am": true, "it": true, "its": true, "this": true, "that": true, "i": true, "you": true,
+	"he": true, "she": true, "we": true, "they": true, "me": true, "my": true, "your": true, "his": true, "her": true, "our": true,
+	"their": true, "so": true, "do": true, "does": true, "did": true, "have": true, "has": true, "had": true, "not": true, "just": true,
+}
+
+// functionWords are words that leave a line dangling when it ends on them
+var functionWords = map[string]bool{
+	"a": true, "an": true, "the": true, "and": true, "or": true, "but": true, "if": true, "of": true, "to": true, "in": true,
+	"on": true, "at": true, "by": true, "for": true, "with": true, "from": true, "as": true, "is": true, "are": true, "his": true,
+	"he": true, "she": true, "our": true, "my": true, "your": true, "their": true, "her": true, "than": true, "that": true, "nor": true,
+}
+
+// Scorer rates Poems for quality
+type Scorer struct {
+	Weights       ScoreWeights
+	StopWords     map[string]bool
+	FunctionWords map[string]bool
+	// LineBreakChecker, if set, zeroes the break score of lines it penalizes
+	LineBreakChecker *LineBreakChecker
+}
+
+// NewScorer returns a Scorer using DefaultScoreWeights and the built in stop and function word lists
+func NewScorer() *Scorer {
+	return &Scorer{Weights: DefaultScoreWeights, StopWords: stopWords, FunctionWords: functionWords}
+}
+
+// isEmoji checks if a character is an emoji or other pictographic symbol
+func isEmoji(char rune) bool {
+	return unicode.Is(unicode.So, char) || (char >= 0x1F000 && char <= 0x1FAFF) || (char >= 0x2600 && char <= 0x27BF)
+}
+
+// textWords returns the words of text that could have been part of a poem, skipping links and @mentions
+func textWords(text string) []string {
+	words := []string{}
+	for _, field := range strings.Fields(text) {
+		if strings.HasPrefix(field, "http") || strings.HasPrefix(field, "@") {
+			continue
+		}
+		words = append(words, field)
+	}
+	return words
+}
+
+// lastWord returns the last word in a line that has syllables, and whether the line ends on punctuation after it
+func lastWord(line Sentence) (*Word, bool) {
+	endsOnPunct := false
+	for i := len(line) - 1; i >= 0; i-- {
+		if line[i].Syllables > 0 {
+			return &line[i], endsOnPunct
+		}
+		if IsSymbolOrPunct(&line[i].Word) {
+			endsOnPunct = true
+		}
+	}
+	return nil, endsOnPunct
+}
+
+// Score rates a poem found in text, where paragraph is the Paragraph the poem was found in
+func (s *Scorer) Score(poem Poem, paragraph Paragraph, text string) Score {
+	score := Score{}
+	if len(poem.Lines) == 0 {
+		return score
+	}
+
+	penalized := map[int]bool{}
+	if s.LineBreakChecker != nil {
+		for _, lineIndex := range s.LineBreakChecker.Penalized(poem) {
+			penalized[lineIndex] = true
+		}
+	}
+
+	poemWords, poemStopWords, goodBreaks, goodEndings := 0, 0, 0.0, 0
+	for lineIndex, line := range poem.Lines {
+		for _, word := range line {
+			if word.Syllables == 0 {
+				continue
+			}
+			poemWords++
+			if s.StopWords[strings.ToLower(word.Word.Text)] {
+				poemStopWords++
+			}
+		}
+		word, endsOnPunct := lastWord(line)
+		dangling := word != nil && s.FunctionWords[strings.ToLower(word.Word.Text)]
+		if !dangling {
+			goodEndings++
+		}
+		isFinalLine := lineIndex == len(poem.Lines)-1
+		switch {
+		case penalized[lineIndex]:
+		case endsOnPunct:
+			goodBreaks++
+		case isFinalLine && poem.End >= len(paragraph.toCombinedSentence()):
+			// poem runs to the end of the text
+			goodBreaks++
+		case !dangling:
+			// no punctuation, but could still be a phrase boundary
+			goodBreaks += 0.5
+		}
+	}
+	score.LineBreaks = goodBreaks / float64(len(poem.Lines))
+	score.Endings = float64(goodEndings) / float64(len(poem.Lines))
+	if poemWords > 0 {
+		score.StopWords = 1 - float64(poemStopWords)/float64(poemWords)
+	}
+	if totalWords := len(textWords(text)); totalWords > 0 {
+		score.Coverage = float64(poemWords) / float64(totalWords)
+		if score.Coverage > 1 {
+			score.Coverage = 1
+		}
+	}
+
+	score.Clean = 1
+	if strings.Contains(text, "http://") || strings.Contains(text, "https://") {
+		score.Clean -= 0.5
+	}
+	for _, char := range text {
+		if isEmoji(char) {
+			score.Clean -= 0.5
+			break
+		}
+	}
+
+	w := s.Weights
+	totalWeight := w.LineBreaks + w.Coverage + w.StopWords + w.Clean + w.Endings
+	if totalWeight > 0 {
+		score.Total = (w.LineBreaks*score.LineBreaks + w.Coverage*score.Coverage + w.StopWords*score.StopWords +
+			w.Clean*score.Clean + w.Endings*score.Endings) / totalWeight
+	}
+	return score
+}
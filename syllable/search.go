@@ -0,0 +1,60 @@
+package syllable
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SearchMode determines which words of a Paragraph are tried as the first word of a poem
+type SearchMode int
+
+const (
+	// SentenceStarts tries the first word of each sentence as the start of a poem
+	SentenceStarts SearchMode = iota
+	// ClauseBoundaries tries the first word of each sentence, and any word following punctuation, as the start of a poem
+	ClauseBoundaries
+	// EveryWord tries every word as the start of a poem
+	EveryWord
+)
+
+var searchModeNames = map[string]SearchMode{
+	"sentence": SentenceStarts,
+	"clause":   ClauseBoundaries,
+	"word":     EveryWord,
+}
+
+// ParseSearchMode converts one of sentence, clause or word into a SearchMode. An empty name is SentenceStarts. Errors if name is not a known search mode
+func ParseSearchMode(name string) (SearchMode, error) {
+	if name == "" {
+		return SentenceStarts, nil
+	}
+	mode, exists := searchModeNames[strings.ToLower(name)]
+	if !exists {
+		return SentenceStarts, errors.Errorf("Unknown search mode %v", name)
+	}
+	return mode, nil
+}
+
+// startIndexes returns the indexes of all words in the paragraph's combined sentence that should be tried as the start of a poem for the search mode
+func (p Paragraph) startIndexes(mode SearchMode) []int {
+	sentenceStarts := map[int]bool{}
+	wordIndex := 0
+	for _, sentence := range p {
+		sentenceStarts[wordIndex] = true
+		wordIndex += len(sentence)
+	}
+	combined := p.toCombinedSentence()
+	starts := []int{}
+	for i := range combined {
+		switch {
+		case sentenceStarts[i]:
+			starts = append(starts, i)
+		case mode == EveryWord && combined[i].Syllables > 0:
+			starts = append(starts, i)
+		case mode == ClauseBoundaries && combined[i].Syllables > 0 && combined[i-1].Syllables == 0 && IsSymbolOrPunct(&combined[i-1].Word):
+			starts = append(starts, i)
+		}
+	}
+	return starts
+}
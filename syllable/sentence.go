@@ -1,6 +1,6 @@
 package syllable
 
-// Sentence is a slice of words, used for subdividing words when generating a HAiku
+// Sentence is a slice of words, used for subdividing words when generating a Poem
 type Sentence []Word
 
 // TotalSyllables counts syllables of all Words in the sentence
@@ -12,39 +12,82 @@ func (s Sentence) TotalSyllables() int {
 	return total
 }
 
-// Subdivide splits a Sentence into a slice of Sentences, splitting on syllable boundaries that are specified in sylSizes, and returning a Haiku
-func (s Sentence) Subdivide(sylSizes ...int) Haiku {
-	curSentence := Haiku{}
-	wordIndex := 0
-	if s.TotalSyllables() < 17 {
-		return curSentence
+// maxSyllables counts syllables of all Words in the sentence, using the largest alternate count for each word
+func (s Sentence) maxSyllables() int {
+	total := 0
+	for _, word := range s {
+		max := word.Syllables
+		for _, alternate := range word.Alternates {
+			if alternate > max {
+				max = alternate
+			}
+		}
+		total += max
 	}
+	return total
+}
+
+// counts returns all possible syllable counts for the word, primary count first
+func (w Word) counts() []int {
+	return append([]int{w.Syllables}, w.Alternates...)
+}
+
+// Subdivide splits a Sentence into a slice of Sentences, splitting on syllable boundaries that are specified in sylSizes, and returning a Poem with a line for each size.
+// Alternate syllable counts of each word are tried if the primary counts do not fit, and the Words in the returned Poem carry the counts that were used
+func (s Sentence) Subdivide(sylSizes ...int) Poem {
+	totalSize := 0
 	for _, sylSize := range sylSizes {
-		curLineSize := 0
-		haikuLine := Sentence{}
-		for ; wordIndex < len(s); wordIndex++ {
-			curWord := s[wordIndex]
-			curSize := curWord.Syllables
-			if curWord.Syllables == 0 {
-				// append any symbols or punctuation
-				haikuLine = append(haikuLine, s[wordIndex])
-				continue
-			}
-			if curLineSize == sylSize {
-				// next line of haiku
-				break
-			}
-			if curLineSize+curSize > sylSize {
-				// we're over, give up
-				return Haiku{}
-			}
-			curLineSize += curSize
-			haikuLine = append(haikuLine, s[wordIndex])
+		totalSize += sylSize
+	}
+	if len(sylSizes) == 0 || s.maxSyllables() < totalSize {
+		return Poem{}
+	}
+	lines, found := s.subdivideFrom(0, sylSizes)
+	if !found {
+		return Poem{}
+	}
+	wordCount := 0
+	for _, line := range lines {
+		wordCount += len(line)
+	}
+	return Poem{Lines: lines, Start: 0, End: wordCount}
+}
+
+func (s Sentence) subdivideFrom(wordIndex int, sylSizes []int) ([]Sentence, bool) {
+	if len(sylSizes) == 0 {
+		return []Sentence{}, true
+	}
+	return s.fillLine(wordIndex, sylSizes, Sentence{}, 0)
+}
+
+// fillLine appends words to the current line until it reaches sylSizes[0] syllables, backtracking over alternate counts when a line runs over
+func (s Sentence) fillLine(wordIndex int, sylSizes []int, poemLine Sentence, curLineSize int) ([]Sentence, bool) {
+	for ; wordIndex < len(s) && s[wordIndex].Syllables == 0; wordIndex++ {
+		// append any symbols or punctuation
+		poemLine = append(poemLine[:len(poemLine):len(poemLine)], s[wordIndex])
+	}
+	if curLineSize == sylSizes[0] {
+		// next line of poem
+		rest, found := s.subdivideFrom(wordIndex, sylSizes[1:])
+		if !found {
+			return nil, false
+		}
+		return append([]Sentence{poemLine}, rest...), true
+	}
+	if wordIndex >= len(s) {
+		return nil, false
+	}
+	for _, count := range s[wordIndex].counts() {
+		if curLineSize+count > sylSizes[0] {
+			// we're over, try the next count
+			continue
 		}
-		if curLineSize == sylSize {
-			curSentence = append(curSentence, haikuLine)
+		curWord := s[wordIndex]
+		curWord.Syllables = count
+		nextLine := append(poemLine[:len(poemLine):len(poemLine)], curWord)
+		if lines, found := s.fillLine(wordIndex+1, sylSizes, nextLine, curLineSize+count); found {
+			return lines, true
 		}
-
 	}
-	return curSentence
+	return nil, false
 }
@@ -0,0 +1,70 @@
+package syllable
+
+// CorpusSnapshot is a read only copy of a CMUCorpus, taken once the corpus is configured. It is safe for concurrent use by any number of goroutines, as nothing can modify it.
+// Its Expand, CorpusExpand and Fallback functions are shared with the corpus it was taken from, and must also be safe for concurrent use. The built in ones are.
+// CorpusExpand functions are called with the snapshot's own copy of the corpus, so a method value such as cmu.ExpandHashtag should be added as (*CMUCorpus).ExpandHashtag instead of to Expand
+type CorpusSnapshot struct {
+	corpus *CMUCorpus
+}
+
+// Snapshot copies the corpus into a CorpusSnapshot. Later changes to the corpus, its dictionary or its PreProcess, Expand and CorpusExpand slices do not affect the snapshot
+func (c *CMUCorpus) Snapshot() *CorpusSnapshot {
+	frozen := *c
+	frozen.PreProcess = append([]PreProcessFunc{}, c.PreProcess...)
+	frozen.Expand = append([]TokenExpandFunc{}, c.Expand...)
+	frozen.CorpusExpand = append([]CorpusExpandFunc{}, c.CorpusExpand...)
+	frozen.Dict = make(map[string][]int, len(c.Dict))
+	for word, counts := range c.Dict {
+		frozen.Dict[word] = append([]int{}, counts...)
+	}
+	frozen.overlays = make(map[string]string, len(c.overlays))
+	for word, overlayPath := range c.overlays {
+		frozen.overlays[word] = overlayPath
+	}
+	return &CorpusSnapshot{corpus: &frozen}
+}
+
+// MinSyllables returns the MinSyllables of the corpus the snapshot was taken from
+func (s *CorpusSnapshot) MinSyllables() int {
+	return s.corpus.MinSyllables
+}
+
+// Tagging returns whether Words are part of speech tagged, see CMUCorpus.Tagging
+func (s *CorpusSnapshot) Tagging() bool {
+	return s.corpus.Tagging
+}
+
+// NewParagraph converts text to a Paragraph, see CMUCorpus.NewParagraph
+func (s *CorpusSnapshot) NewParagraph(text string) (Paragraph, error) {
+	return s.corpus.NewParagraph(text)
+}
+
+// NewSentence converts a sentence to a Sentence, see CMUCorpus.NewSentence
+func (s *CorpusSnapshot) NewSentence(sentence string, filters ...TokenFilterFunc) (Sentence, error) {
+	return s.corpus.NewSentence(sentence, filters...)
+}
+
+// SyllableCount returns the syllable count of the primary pronunciation of word, see CMUCorpus.SyllableCount
+func (s *CorpusSnapshot) SyllableCount(word string) (int, error) {
+	return s.corpus.SyllableCount(word)
+}
+
+// SyllableCounts returns the syllable counts of all pronunciations of word, see CMUCorpus.SyllableCounts.
+// The returned slice is a copy, so that callers cannot modify the snapshot through it
+func (s *CorpusSnapshot) SyllableCounts(word string) ([]int, error) {
+	counts, err := s.corpus.SyllableCounts(word)
+	if err != nil {
+		return nil, err
+	}
+	return append([]int{}, counts...), nil
+}
+
+// Source describes where the syllable count of word comes from, see CMUCorpus.Source
+func (s *CorpusSnapshot) Source(word string) (string, error) {
+	return s.corpus.Source(word)
+}
+
+// HasSyllableCount checks if word has a syllable count, see CMUCorpus.HasSyllableCount
+func (s *CorpusSnapshot) HasSyllableCount(word string) bool {
+	return s.corpus.HasSyllableCount(word)
+}
@@ -1,7 +1,12 @@
 package syllable
 
 import (
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
+
+	prose "gopkg.in/antipasta/prose.v2"
 )
 
 type ExpectedHaiku struct {
@@ -93,7 +98,7 @@ func TestProblematicHaikus(t *testing.T) {
 		},
 		{
 			Input:          "How many cans of tuna are ok to eat at once? They’re so small...",
-			ExpectedOutput: [3]string{"How many cans of", "tuna are ok to eat", "at once? They're so small..."},
+			ExpectedOutput: [3]string{"How many cans of", "tuna are ok to eat", "at once? They’re so small..."},
 			Corpus:         cmu,
 		},
 		{
@@ -122,3 +127,426 @@ func (eh *ExpectedHaiku) HaikuTest(t *testing.T) []Haiku {
 	}
 	return foundHaikus
 }
+
+func TestAlternatePronunciations(t *testing.T) {
+	cmu, err := NewCMUCorpus("cmudict.dict")
+	if err != nil {
+		t.Errorf("Error loading cmu dictionary %+v", err)
+	}
+	if _, exists := cmu.Dict["every(2)"]; exists {
+		t.Errorf("Alternate pronunciation markers should not be stored as separate words")
+	}
+	counts, err := cmu.SyllableCounts("Every")
+	if err != nil {
+		t.Errorf("Error getting counts for every: %v", err)
+	}
+	if len(counts) != 2 || counts[0] != 3 || counts[1] != 2 {
+		t.Errorf("Expected counts [3 2] for every, got %v", counts)
+	}
+	eh := ExpectedHaiku{
+		Input:          "every family goes to the park on sunday, to eat lunch and play",
+		ExpectedOutput: [3]string{"every family", "goes to the park on sunday,", "to eat lunch and play"},
+		Corpus:         cmu,
+	}
+	foundHaikus := eh.HaikuTest(t)
+	if len(foundHaikus) > 0 && foundHaikus[0].Lines[0][1].Syllables != 2 {
+		t.Errorf("Expected haiku to use alternate count of 2 for family, got %d", foundHaikus[0].Lines[0][1].Syllables)
+	}
+}
+
+func TestEstimateSyllables(t *testing.T) {
+	cases := map[string]int{
+		"uwu":     2,
+		"yeet":    1,
+		"hoped":   1,
+		"wanted":  2,
+		"lakes":   1,
+		"boxes":   2,
+		"bottle":  2,
+		"stadium": 3,
+		"smh":     3,
+	}
+	for word, expected := range cases {
+		count, ok := EstimateSyllables(word)
+		if !ok {
+			t.Errorf("Expected an estimate for %s", word)
+		}
+		if count != expected {
+			t.Errorf("Expected estimate of %d for %s, got %d", expected, word, count)
+		}
+	}
+	if _, ok := EstimateSyllables("#wow"); ok {
+		t.Errorf("Should not estimate hashtags")
+	}
+
+	cmu, err := NewCMUCorpus("cmudict.dict")
+	if err != nil {
+		t.Errorf("Error loading cmu dictionary %+v", err)
+	}
+	cmu.Fallback = EstimateSyllables
+	sentence, err := cmu.NewSentence("an unknown word, argblarg")
+	if err != nil {
+		t.Errorf("Should get no error when fallback is set, got %s", err)
+	}
+	if len(sentence) != 5 || !sentence[4].Estimated || sentence[0].Estimated {
+		t.Errorf("Expected only argblarg to be estimated, got %+v", sentence)
+	}
+}
+
+func TestExpandNumber(t *testing.T) {
+	cases := map[string]string{
+		"64th":   "sixty fourth",
+		"2019":   "twenty nineteen",
+		"1905":   "nineteen oh five",
+		"2005":   "two thousand five",
+		"$5":     "five dollars",
+		"$5.50":  "five dollars and fifty cents",
+		"10%":    "ten percent",
+		"3:30":   "three thirty",
+		"3:00":   "three o'clock",
+		"5pm":    "five p m",
+		"1,200":  "one thousand two hundred",
+		"3.14":   "three point one four",
+		"1st":    "first",
+		"112":    "one hundred twelve",
+		"22nd":   "twenty second",
+		"100000": "one hundred thousand",
+	}
+	for token, expected := range cases {
+		words, ok := ExpandNumber(token)
+		if !ok {
+			t.Errorf("Expected %s to be expanded", token)
+		}
+		if strings.Join(words, " ") != expected {
+			t.Errorf("Expected %s to expand to [%s], got [%s]", token, expected, strings.Join(words, " "))
+		}
+	}
+	if _, ok := ExpandNumber("abc"); ok {
+		t.Errorf("Should not expand words")
+	}
+
+	cmu, err := NewCMUCorpus("cmudict.dict")
+	if err != nil {
+		t.Errorf("Error loading cmu dictionary %+v", err)
+	}
+	eh := ExpectedHaiku{
+		Input:          "now my 64th birthday cost me $5, left at 3:30",
+		ExpectedOutput: [3]string{"now my 64th", "birthday cost me $5,", "left at 3:30"},
+		Corpus:         cmu,
+	}
+	eh.HaikuTest(t)
+}
+
+func TestExpandHashtag(t *testing.T) {
+	cmu, err := NewCMUCorpus("cmudict.dict")
+	if err != nil {
+		t.Errorf("Error loading cmu dictionary %+v", err)
+	}
+	cases := map[string]string{
+		"#HoneyBadgerDontCare": "honey badger don't care",
+		"@FCBarcelona":         "f c barcelona",
+		"#honeybadger":         "honey badger",
+		"#Xqzvbn":              "",
+		"#throwback_thursday":  "throwback thursday",
+	}
+	for token, expected := range cases {
+		words, ok := cmu.ExpandHashtag(token)
+		if expected == "" {
+			if ok {
+				t.Errorf("Expected %s not to be expanded, got %v", token, words)
+			}
+			continue
+		}
+		if strings.Join(words, " ") != expected {
+			t.Errorf("Expected %s to expand to [%s], got [%s]", token, expected, strings.Join(words, " "))
+		}
+	}
+
+	cmu.CorpusExpand = append(cmu.CorpusExpand, (*CMUCorpus).ExpandHashtag)
+	eh := ExpectedHaiku{
+		Input:          "we watched the game and #HoneyBadgerDontCare, yeah @NBAFinals",
+		ExpectedOutput: [3]string{"we watched the game and", "#HoneyBadgerDontCare, yeah", "@NBAFinals"},
+		Corpus:         cmu,
+	}
+	eh.HaikuTest(t)
+}
+
+func TestOverlayDictionaries(t *testing.T) {
+	first, err := ioutil.TempFile("", "overlay")
+	if err != nil {
+		t.Fatalf("Error creating overlay file %v", err)
+	}
+	defer os.Remove(first.Name())
+	first.WriteString(";;; simple and cmu style entries\nuwu 2\nPelosi P AH0 L OW1 S IY0\nfire 1\n")
+	first.Close()
+	second, err := ioutil.TempFile("", "overlay")
+	if err != nil {
+		t.Fatalf("Error creating overlay file %v", err)
+	}
+	defer os.Remove(second.Name())
+	second.WriteString("uwu 3\n")
+	second.Close()
+
+	cmu, err := NewCMUCorpus("cmudict.dict", first.Name(), second.Name())
+	if err != nil {
+		t.Fatalf("Error loading cmu dictionary with overlays %+v", err)
+	}
+	expectedCounts := map[string]int{"uwu": 3, "pelosi": 3, "fire": 1, "every": 3}
+	for word, expected := range expectedCounts {
+		counts, err := cmu.SyllableCounts(word)
+		if err != nil {
+			t.Errorf("Error getting counts for %s: %v", word, err)
+			continue
+		}
+		if counts[0] != expected || (word == "fire" && len(counts) != 1) {
+			t.Errorf("Expected %d syllables for %s, got %v", expected, word, counts)
+		}
+	}
+	expectedSources := map[string]string{
+		"uwu":    "overlay " + second.Name(),
+		"Pelosi": "overlay " + first.Name(),
+		"every":  "corpus cmudict.dict",
+		"64th":   "expansion",
+	}
+	for word, expected := range expectedSources {
+		source, err := cmu.Source(word)
+		if err != nil || source != expected {
+			t.Errorf("Expected source [%s] for %s, got [%s] %v", expected, word, source, err)
+		}
+	}
+	if _, err := cmu.Source("argblarg"); err == nil {
+		t.Errorf("Should get an error for the source of an unknown word")
+	}
+}
+
+func TestSearchModes(t *testing.T) {
+	cmu, err := NewCMUCorpus("cmudict.dict")
+	if err != nil {
+		t.Errorf("Error loading cmu dictionary %+v", err)
+	}
+	p, err := cmu.NewParagraph("well, this is a haiku, hope the test finds it alright, i think that it should")
+	if err != nil {
+		t.Errorf("Error creating paragraph %s", err)
+	}
+	if found := p.Search(SentenceStarts, 5, 7, 5); len(found) != 0 {
+		t.Errorf("Expected no haikus starting at sentence starts, got %+v", found)
+	}
+	expected := [3]string{"this is a haiku,", "hope the test finds it alright,", "i think that it should"}
+	for _, mode := range []SearchMode{ClauseBoundaries, EveryWord} {
+		found := p.Search(mode, 5, 7, 5)
+		if len(found) != 1 {
+			t.Errorf("Expected 1 haiku for search mode %d, got %+v", mode, found)
+			continue
+		}
+		if found[0].ToStringArray() != expected {
+			t.Errorf("Output [%v] does not match expected [%+v]", found[0].ToStringArray(), expected)
+		}
+		if found[0].Start != 2 || found[0].End != 19 {
+			t.Errorf("Expected span 2-19, got %d-%d", found[0].Start, found[0].End)
+		}
+	}
+	if _, err := ParseSearchMode("paragraph"); err == nil {
+		t.Errorf("Should get an error for an unknown search mode")
+	}
+}
+
+func TestScorer(t *testing.T) {
+	cmu, err := NewCMUCorpus("cmudict.dict")
+	if err != nil {
+		t.Errorf("Error loading cmu dictionary %+v", err)
+	}
+	scorer := NewScorer()
+	goodText := "this is a haiku. hope the test finds it alright, i think that it should."
+	badText := "@startingjunk #hi testing a trim of both starting and trailing junk in the same sentence #devlyfe https://t.co/abc"
+	scores := []Score{}
+	for _, text := range []string{goodText, badText} {
+		p, err := cmu.NewParagraph(text)
+		if err != nil {
+			t.Errorf("Error creating paragraph %s", err)
+		}
+		found := p.Subdivide(5, 7, 5)
+		if len(found) == 0 {
+			t.Fatalf("Found no haikus for text %v", text)
+		}
+		scores = append(scores, scorer.Score(found[0], p, text))
+	}
+	good, bad := scores[0], scores[1]
+	if good.LineBreaks != 1 || good.Endings != 1 || good.Clean != 1 || good.Coverage != 1 {
+		t.Errorf("Expected perfect line breaks, endings, cleanliness and coverage for %s, got %+v", goodText, good)
+	}
+	if bad.Endings >= 1 || bad.Clean >= 1 {
+		t.Errorf("Expected dangling ending and unclean text to be penalized for %s, got %+v", badText, bad)
+	}
+	if good.Total <= bad.Total {
+		t.Errorf("Expected %+v to score higher than %+v", good, bad)
+	}
+}
+
+func taggedLine(words ...string) Sentence {
+	line := Sentence{}
+	for i := 0; i+1 < len(words); i += 2 {
+		syllables := 1
+		if len(words[i]) == 1 && words[i+1] == words[i] {
+			syllables = 0
+		}
+		line = append(line, Word{Word: prose.Token{Text: words[i], Tag: words[i+1]}, Syllables: syllables})
+	}
+	return line
+}
+
+func TestLineBreakChecker(t *testing.T) {
+	poem := Poem{Lines: []Sentence{
+		taggedLine("testing", "VBG", "a", "DT", "trim", "NN", "of", "IN"),
+		taggedLine("the", "DT", "honey", "NN"),
+		taggedLine("badger", "NN", "has", "VBZ"),
+		taggedLine("it", "PRP", "is", "VBZ", ".", "."),
+	}}
+	if final := poem.FinalWord(); final != "." {
+		t.Errorf("Expected final word to be the last token, got %q", final)
+	}
+	if final := (Poem{Lines: []Sentence{taggedLine("pond", "NN"), {}}}).FinalWord(); final != "" {
+		t.Errorf("Expected no final word for an empty final line, got %q", final)
+	}
+	checker := &LineBreakChecker{}
+	badBreaks := checker.BadBreaks(poem)
+	if len(badBreaks) != 3 || badBreaks[0] != 0 || badBreaks[1] != 1 || badBreaks[2] != 2 {
+		t.Errorf("Expected bad breaks on lines 0, 1 and 2, got %v", badBreaks)
+	}
+	if !checker.Accepts(poem) {
+		t.Errorf("Checker with no rules should accept everything")
+	}
+	checker.Rules = []LineBreakRule{AllowBreak, PenalizeBreak, RejectBreak}
+	if checker.Accepts(poem) {
+		t.Errorf("Checker should reject poem with a dangling auxiliary on line 2")
+	}
+	if penalized := checker.Penalized(poem); len(penalized) != 1 || penalized[0] != 1 {
+		t.Errorf("Expected only line 1 to be penalized, got %v", penalized)
+	}
+	checker.Rules = []LineBreakRule{RejectBreak, AllowBreak}
+	if checker.Accepts(poem) {
+		t.Errorf("Checker should reject poem ending line 0 on a preposition")
+	}
+	if _, err := ParseLineBreakRule("ignore"); err == nil {
+		t.Errorf("Should get an error for an unknown line break rule")
+	}
+}
+
+func TestOriginalText(t *testing.T) {
+	cmu, err := NewCMUCorpus("cmudict.dict")
+	if err != nil {
+		t.Errorf("Error loading cmu dictionary %+v", err)
+	}
+	text := "this is a haiku.  Hope the  test finds it alright, i think that it should &amp; more"
+	eh := ExpectedHaiku{
+		Input:          text,
+		ExpectedOutput: [3]string{"this is a haiku.", "Hope the  test finds it alright,", "i think that it should"},
+		Corpus:         cmu,
+	}
+	found := eh.HaikuTest(t)
+	if len(found) == 0 {
+		return
+	}
+	offsets := found[0].Offsets()
+	if len(offsets) != 3 {
+		t.Fatalf("Expected offsets for 3 lines, got %v", offsets)
+	}
+	for i, line := range found[0].ToStringSlice() {
+		if text[offsets[i][0]:offsets[i][1]] != line {
+			t.Errorf("Offsets %v of line %d do not match line [%s], got [%s]", offsets[i], i, line, text[offsets[i][0]:offsets[i][1]])
+		}
+	}
+
+	p, err := cmu.NewParagraph("Punctuation test; Hope &amp; test that it works right??? Only time, will tell!!!")
+	if err != nil {
+		t.Errorf("Error creating paragraph %s", err)
+	}
+	found = p.Subdivide(5, 7, 5)
+	if len(found) == 0 || found[0].ToStringSlice()[1] != "Hope & test that it works right???" {
+		t.Errorf("Expected html entities to be unescaped in output, got %+v", found)
+	}
+}
+
+func TestTextCleaner(t *testing.T) {
+	testCases := []struct {
+		text         string
+		keepMentions bool
+		expected     string
+	}{
+		{"RT @bob: the old pond https://t.co/abc", false, "the old pond"},
+		{"@a @b @c thanks @d, see you there", false, "thanks, see you there"},
+		{"frogs 🐸! jump", false, "frogs! jump"},
+		{"@a thanks @d, see you there", true, "thanks @d, see you there"},
+		{"frogs🐸jump 👍🏽 in", false, "frogs jump in"},
+		{"email me@example.com later", false, "email me@example.com later"},
+		{"the sound of wa… https://t.co/full", false, "the sound of"},
+		{"and then… silence", false, "and then... silence"},
+		{"www.example.com  is\tnot   a   word", false, "is\tnot a word"},
+	}
+	cleaner := TextCleaner{}
+	for _, testCase := range testCases {
+		cleaner.KeepMentions = testCase.keepMentions
+		cleaned := cleaner.Clean(testCase.text)
+		if cleaned.Text != testCase.expected {
+			t.Errorf("Expected %q to clean to %q, got %q", testCase.text, testCase.expected, cleaned.Text)
+		}
+	}
+
+	text := "@bob 🐸 an old silent pond… a frog jumps in https://t.co/x"
+	cleaned := cleaner.Clean(text)
+	if cleaned.Text != "an old silent pond... a frog jumps in" {
+		t.Fatalf("Unexpected cleaned text %q", cleaned.Text)
+	}
+	start, end := cleaned.OriginalSpan(strings.Index(cleaned.Text, "silent"), strings.Index(cleaned.Text, "silent")+len("silent"))
+	if text[start:end] != "silent" {
+		t.Errorf("Expected span to map back to silent in original, got %q", text[start:end])
+	}
+	start, end = cleaned.OriginalSpan(strings.Index(cleaned.Text, "..."), strings.Index(cleaned.Text, "...")+3)
+	if text[start:end] != "…" {
+		t.Errorf("Expected replaced ellipsis to map back to original, got %q", text[start:end])
+	}
+
+	// spans marked by the source are removed along with what the cleaner finds, even where they overlap it
+	text = "@bob an old pond example.com/frog 🐸 a frog jumps in"
+	cleaned = cleaner.Clean(text, Span{0, 5}, Span{17, 36}, Span{45, 100})
+	if cleaned.Text != "an old pond a frog" {
+		t.Fatalf("Expected removed spans to be cleaned from text, got %q", cleaned.Text)
+	}
+	start, end = cleaned.OriginalSpan(strings.Index(cleaned.Text, "a frog"), len(cleaned.Text))
+	if text[start:end] != "a frog" {
+		t.Errorf("Expected span after removed spans to map back to original, got %q", text[start:end])
+	}
+}
+
+func TestCorpusSnapshot(t *testing.T) {
+	cmu, err := NewCMUCorpus("cmudict.dict")
+	if err != nil {
+		t.Fatalf("Error loading cmu dictionary %+v", err)
+	}
+	cmu.CorpusExpand = append(cmu.CorpusExpand, (*CMUCorpus).ExpandHashtag)
+	snapshot := cmu.Snapshot()
+	cmu.Dict["uwu"] = []int{2}
+	cmu.Dict["every"] = []int{9}
+	cmu.PreProcess = append(cmu.PreProcess, strings.ToUpper)
+	cmu.Fallback = EstimateSyllables
+	if snapshot.HasSyllableCount("uwu") {
+		t.Errorf("Words added to the corpus after a snapshot should not be in the snapshot")
+	}
+	if !cmu.HasSyllableCount("#UwuFrog") || snapshot.HasSyllableCount("#UwuFrog") {
+		t.Errorf("Hashtags should be expanded with the words of the corpus they are counted by")
+	}
+	counts, err := snapshot.SyllableCounts("every")
+	if err != nil || counts[0] != 3 {
+		t.Errorf("Expected snapshot counts to be unchanged, got %v %v", counts, err)
+	}
+	counts[0] = 1
+	if count, _ := snapshot.SyllableCount("every"); count != 3 {
+		t.Errorf("Modifying returned counts should not modify the snapshot, got %v", count)
+	}
+	if _, err := snapshot.SyllableCount("zzyzzxx"); err == nil {
+		t.Errorf("Fallback set after a snapshot should not be used by the snapshot")
+	}
+	paragraph, err := snapshot.NewParagraph("the rain falls &amp; falls")
+	if err != nil || len(paragraph) != 1 || paragraph[0][0].Word.Text != "the" {
+		t.Errorf("Expected snapshot to keep its PreProcess functions, got %+v %v", paragraph, err)
+	}
+}
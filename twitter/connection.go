@@ -0,0 +1,239 @@
+package twitter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/antipasta/wildhaiku/logging"
+	"github.com/antipasta/wildhaiku/source"
+	"github.com/pkg/errors"
+)
+
+// DefaultIdleTimeout is how long the stream can go without sending any data, keepalives included, before it is considered stalled
+const DefaultIdleTimeout = 90 * time.Second
+
+// Backoff intervals prescribed by Twitter's streaming API docs
+const (
+	// NetworkBackoffStep is added to the wait after each consecutive network error or stall
+	NetworkBackoffStep = 250 * time.Millisecond
+	// MaxNetworkBackoff caps the wait after network errors
+	MaxNetworkBackoff = 16 * time.Second
+	// HTTPBackoffStart is the wait after the first HTTP error, doubling after each consecutive one
+	HTTPBackoffStart = 5 * time.Second
+	// MaxHTTPBackoff caps the wait after HTTP errors
+	MaxHTTPBackoff = 320 * time.Second
+	// RateLimitBackoffStart is the wait after the first 420 or 429 response, doubling after each consecutive one
+	RateLimitBackoffStart = time.Minute
+	// MaxRateLimitBackoff caps the wait after 420 and 429 responses
+	MaxRateLimitBackoff = 16 * time.Minute
+)
+
+// ReconnectReason is why the stream had to be reconnected
+type ReconnectReason string
+
+const (
+	// ReconnectNetworkError is a failure to connect or a dropped connection
+	ReconnectNetworkError ReconnectReason = "network"
+	// ReconnectStall is a connection that sent nothing for IdleTimeout
+	ReconnectStall ReconnectReason = "stall"
+	// ReconnectHTTPError is a non-OK response other than a rate limit
+	ReconnectHTTPError ReconnectReason = "http"
+	// ReconnectRateLimited is a 420 or 429 response
+	ReconnectRateLimited ReconnectReason = "rate_limit"
+)
+
+// ReconnectEvent describes a reconnect to the stream, Attempt counts consecutive reconnects for the same reason
+type ReconnectEvent struct {
+	Reason  ReconnectReason
+	Attempt int
+	Wait    time.Duration
+	Err     error
+}
+
+// ReconnectFunc is called before the streamer waits to reconnect
+type ReconnectFunc func(ReconnectEvent)
+
+// StatusError is returned when the stream responds with a non-OK status
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("Received non-OK error code [%v] [%v]: %v", e.StatusCode, e.Status, e.Body)
+}
+
+// reconnectReason classifies an error from connecting or streaming
+func reconnectReason(err error) ReconnectReason {
+	statusErr, isStatus := errors.Cause(err).(*StatusError)
+	if !isStatus {
+		return ReconnectNetworkError
+	}
+	if statusErr.StatusCode == 420 || statusErr.StatusCode == http.StatusTooManyRequests {
+		return ReconnectRateLimited
+	}
+	return ReconnectHTTPError
+}
+
+// backoff tracks consecutive reconnects for each reason, to compute how long to wait before the next one
+type backoff struct {
+	attempts map[ReconnectReason]int
+}
+
+func newBackoff() *backoff {
+	return &backoff{attempts: map[ReconnectReason]int{}}
+}
+
+// next records a reconnect for reason, returning the attempt number and how long to wait: linearly increasing for network errors and stalls, exponentially for HTTP errors and rate limits
+func (b *backoff) next(reason ReconnectReason) (int, time.Duration) {
+	b.attempts[reason]++
+	attempt := b.attempts[reason]
+	switch reason {
+	case ReconnectHTTPError:
+		return attempt, exponential(HTTPBackoffStart, MaxHTTPBackoff, attempt)
+	case ReconnectRateLimited:
+		return attempt, exponential(RateLimitBackoffStart, MaxRateLimitBackoff, attempt)
+	}
+	wait := time.Duration(attempt) * NetworkBackoffStep
+	if wait > MaxNetworkBackoff {
+		wait = MaxNetworkBackoff
+	}
+	return attempt, wait
+}
+
+// reset clears all consecutive reconnect counts, after a connection succeeds
+func (b *backoff) reset() {
+	b.attempts = map[ReconnectReason]int{}
+}
+
+func exponential(start time.Duration, max time.Duration, attempt int) time.Duration {
+	wait := start
+	for i := 1; i < attempt && wait < max; i++ {
+		wait *= 2
+	}
+	if wait > max {
+		wait = max
+	}
+	return wait
+}
+
+// idleTimeoutReader closes body if no read returns within timeout, unblocking a stalled read
+type idleTimeoutReader struct {
+	body    io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	stalled int32
+}
+
+func newIdleTimeoutReader(body io.ReadCloser, timeout time.Duration) *idleTimeoutReader {
+	r := &idleTimeoutReader{body: body, timeout: timeout}
+	r.timer = time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&r.stalled, 1)
+		r.body.Close()
+	})
+	return r
+}
+
+func (r *idleTimeoutReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	if n > 0 {
+		r.timer.Reset(r.timeout)
+	}
+	return n, err
+}
+
+// Close stops the idle timer and closes body
+func (r *idleTimeoutReader) Close() error {
+	r.timer.Stop()
+	return r.body.Close()
+}
+
+// Stalled checks if the body was closed because of the idle timeout
+func (r *idleTimeoutReader) Stalled() bool {
+	return atomic.LoadInt32(&r.stalled) == 1
+}
+
+// connectAndStream connects and streams until the connection fails or ctx is done, returning the event describing why it must be reconnected
+func (ts *Streamer) connectAndStream(ctx context.Context, b *backoff) ReconnectEvent {
+	resp, err := ts.Connect()
+	if err != nil {
+		event := ReconnectEvent{Reason: reconnectReason(err), Err: err}
+		event.Attempt, event.Wait = b.next(event.Reason)
+		return event
+	}
+	b.reset()
+	idleTimeout := ts.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultIdleTimeout
+	}
+	body := newIdleTimeoutReader(resp.Body, idleTimeout)
+	stop := source.CloseOnDone(ctx, body)
+	ts.setConnected(true)
+	err = ts.StreamLoop(body)
+	ts.setConnected(false)
+	stop()
+	body.Close()
+	event := ReconnectEvent{Reason: ReconnectNetworkError, Err: err}
+	if body.Stalled() {
+		event.Reason = ReconnectStall
+		event.Err = errors.Errorf("No data received for %v", idleTimeout)
+	}
+	event.Attempt, event.Wait = b.next(event.Reason)
+	return event
+}
+
+// runDefaults sets the channels, http client and sleep function that Run needs, if the Streamer was not created with NewStreamer
+func (ts *Streamer) runDefaults() {
+	if ts.httpClient == nil {
+		ts.httpClient = &http.Client{}
+	}
+	if ts.ProcessChannel == nil {
+		ts.ProcessChannel = make(chan *source.Post, 10000)
+	}
+	if ts.DeletionChannel == nil {
+		ts.DeletionChannel = make(chan *source.Deletion, 10000)
+	}
+	if ts.lookupChannel == nil {
+		ts.lookupChannel = make(chan *Tweet, lookupQueueSize)
+	}
+	if ts.sleep == nil {
+		ts.sleep = source.Sleep
+	}
+}
+
+// Run connects to the twitter stream and reads from it until ctx is done, reconnecting whenever the connection fails, stalls or the stream ends.
+// Reconnects back off as prescribed by Twitter's docs, and are reported to OnReconnect if set. Truncated tweets are looked up in the background while streaming
+func (ts *Streamer) Run(ctx context.Context) error {
+	ts.runDefaults()
+	defer close(ts.ProcessChannel)
+	defer close(ts.DeletionChannel)
+	lookupsDone := make(chan struct{})
+	go func() {
+		ts.runLookups(ctx)
+		close(lookupsDone)
+	}()
+	defer func() {
+		// tweets still waiting for a lookup are sent before ProcessChannel is closed
+		close(ts.lookupChannel)
+		<-lookupsDone
+	}()
+	b := newBackoff()
+	for {
+		event := ts.connectAndStream(ctx, b)
+		if ctx.Err() != nil {
+			return nil
+		}
+		logging.OrDefault(ts.Logger).Warn("Reconnecting to twitter stream", "wait", event.Wait, "reason", event.Reason, "attempt", event.Attempt, "error", event.Err)
+		if ts.OnReconnect != nil {
+			ts.OnReconnect(event)
+		}
+		if !ts.sleep(ctx, event.Wait) {
+			return nil
+		}
+	}
+}
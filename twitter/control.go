@@ -0,0 +1,106 @@
+package twitter
+
+import (
+	"strconv"
+
+	"github.com/antipasta/wildhaiku/logging"
+	"github.com/antipasta/wildhaiku/source"
+)
+
+// Delete is a notice that a tweet was deleted and must no longer be displayed
+type Delete struct {
+	Status struct {
+		IDStr     string `json:"id_str"`
+		UserIDStr string `json:"user_id_str"`
+	} `json:"status"`
+}
+
+// Limit is a notice that more tweets matched the stream than could be delivered. Track is the number of undelivered tweets since the connection was opened
+type Limit struct {
+	Track       int    `json:"track"`
+	TimestampMS string `json:"timestamp_ms"`
+}
+
+// Disconnect is sent before the stream is closed by Twitter, with the reason it is being closed
+type Disconnect struct {
+	Code       int    `json:"code"`
+	StreamName string `json:"stream_name"`
+	Reason     string `json:"reason"`
+}
+
+// Warning is a stall warning, sent when the stream is not being read fast enough
+type Warning struct {
+	Code        string `json:"code"`
+	Message     string `json:"message"`
+	PercentFull int    `json:"percent_full"`
+}
+
+// StatusWithheld is a notice that a tweet has been withheld in some countries, and must no longer be displayed there
+type StatusWithheld struct {
+	ID                  int64    `json:"id"`
+	UserID              int64    `json:"user_id"`
+	WithheldInCountries []string `json:"withheld_in_countries"`
+}
+
+// ControlMessage is a message on the v1.1 stream that is not a tweet. Exactly one field is set
+type ControlMessage struct {
+	Delete         *Delete         `json:"delete,omitempty"`
+	Limit          *Limit          `json:"limit,omitempty"`
+	Disconnect     *Disconnect     `json:"disconnect,omitempty"`
+	Warning        *Warning        `json:"warning,omitempty"`
+	StatusWithheld *StatusWithheld `json:"status_withheld,omitempty"`
+}
+
+// ControlFunc is called with every control message read off of the stream
+type ControlFunc func(ControlMessage)
+
+// isSet checks if the message holds any control message
+func (cm *ControlMessage) isSet() bool {
+	return cm.Delete != nil || cm.Limit != nil || cm.Disconnect != nil || cm.Warning != nil || cm.StatusWithheld != nil
+}
+
+// Deletion returns the source.Deletion for a delete or status_withheld message, nil for other messages
+func (cm *ControlMessage) Deletion() *source.Deletion {
+	switch {
+	case cm.Delete != nil:
+		return &source.Deletion{ID: cm.Delete.Status.IDStr, Reason: "delete"}
+	case cm.StatusWithheld != nil:
+		return &source.Deletion{ID: strconv.FormatInt(cm.StatusWithheld.ID, 10), Reason: "withheld"}
+	}
+	return nil
+}
+
+// streamMessage is any message on the v1.1 stream, a tweet or a control message
+type streamMessage struct {
+	Tweet
+	ControlMessage
+}
+
+// Deletions returns the channel that deleted and withheld tweets are reported on
+func (ts *Streamer) Deletions() <-chan *source.Deletion {
+	return ts.DeletionChannel
+}
+
+// handleControl reports a control message to OnControl, and sends deleted and withheld tweets to the DeletionChannel.
+// Deletions are dropped rather than waited on when the DeletionChannel is full, so that the stream is not stalled by whatever reads it
+func (ts *Streamer) handleControl(cm ControlMessage) {
+	switch {
+	case cm.Limit != nil:
+		logging.OrDefault(ts.Logger).Warn("Stream limited", "undelivered", cm.Limit.Track)
+	case cm.Disconnect != nil:
+		logging.OrDefault(ts.Logger).Warn("Stream disconnect", "stream", cm.Disconnect.StreamName, "code", cm.Disconnect.Code, "reason", cm.Disconnect.Reason)
+	case cm.Warning != nil:
+		logging.OrDefault(ts.Logger).Warn("Stream warning", "code", cm.Warning.Code, "percent_full", cm.Warning.PercentFull, "message", cm.Warning.Message)
+	}
+	if ts.OnControl != nil {
+		ts.OnControl(cm)
+	}
+	if deletion := cm.Deletion(); deletion != nil && deletion.ID != "" && deletion.ID != "0" {
+		select {
+		case ts.DeletionChannel <- deletion:
+		default:
+			logging.OrDefault(ts.Logger).Warn("Deletion queue is full, dropping deletion", "id", deletion.ID, "reason", deletion.Reason)
+			ts.event(DeletionDropped)
+		}
+	}
+}
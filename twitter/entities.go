@@ -0,0 +1,146 @@
+package twitter
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/antipasta/wildhaiku/source"
+)
+
+// Indices are the start and end of an entity in a tweet's text, counted in unicode code points with html entities escaped
+type Indices [2]int
+
+// URLEntity is a link in a tweet's text, shortened to a t.co URL
+type URLEntity struct {
+	URL         string  `json:"url"`
+	ExpandedURL string  `json:"expanded_url"`
+	DisplayURL  string  `json:"display_url"`
+	Indices     Indices `json:"indices"`
+}
+
+// MentionEntity is an @mention of a user in a tweet's text
+type MentionEntity struct {
+	ScreenName string  `json:"screen_name"`
+	Name       string  `json:"name"`
+	IDStr      string  `json:"id_str"`
+	Indices    Indices `json:"indices"`
+}
+
+// HashtagEntity is a #hashtag in a tweet's text, Text excludes the #
+type HashtagEntity struct {
+	Text    string  `json:"text"`
+	Indices Indices `json:"indices"`
+}
+
+// MediaEntity is a photo, video or gif attached to a tweet, linked to by a t.co URL at the end of its text
+type MediaEntity struct {
+	IDStr         string  `json:"id_str"`
+	Type          string  `json:"type"`
+	URL           string  `json:"url"`
+	MediaURLHTTPS string  `json:"media_url_https"`
+	DisplayURL    string  `json:"display_url"`
+	Indices       Indices `json:"indices"`
+}
+
+// Entities are the links, mentions, hashtags and media parsed out of a tweet's text by Twitter
+type Entities struct {
+	URLs         []URLEntity     `json:"urls,omitempty"`
+	UserMentions []MentionEntity `json:"user_mentions,omitempty"`
+	Hashtags     []HashtagEntity `json:"hashtags,omitempty"`
+	Media        []MediaEntity   `json:"media,omitempty"`
+}
+
+// FullEntities returns the entities of the tweet's full text. t.ExtendedTweet.Entities if the tweet is extended, else t.Entities
+func (t *Tweet) FullEntities() Entities {
+	if t.ExtendedTweet != nil && len(t.ExtendedTweet.FullText) > 0 {
+		return t.ExtendedTweet.Entities
+	}
+	return t.Entities
+}
+
+// FullDisplayTextRange returns the part of the full text that is displayed as the body of the tweet, which excludes leading reply mentions and trailing media links.
+// The whole text if the tweet has no display_text_range
+func (t *Tweet) FullDisplayTextRange() Indices {
+	displayRange := t.DisplayTextRange
+	if t.ExtendedTweet != nil && len(t.ExtendedTweet.FullText) > 0 {
+		displayRange = t.ExtendedTweet.DisplayTextRange
+	}
+	textLength := len([]rune(t.FullText()))
+	if displayRange == nil || displayRange[1] <= displayRange[0] || displayRange[1] > textLength {
+		return Indices{0, textLength}
+	}
+	return *displayRange
+}
+
+// removedSpans returns the byte spans of the full text that are dropped when cleaning, in order: everything outside of the display range, ie reply mentions and media links, and links and media
+func (t *Tweet) removedSpans() []source.Span {
+	text := t.FullText()
+	// byte offset of each code point, and of the end of the text
+	offsets := []int{}
+	for i := range text {
+		offsets = append(offsets, i)
+	}
+	offsets = append(offsets, len(text))
+	byteSpan := func(indices Indices) source.Span {
+		start, end := indices[0], indices[1]
+		if start < 0 {
+			start = 0
+		}
+		if end > len(offsets)-1 {
+			end = len(offsets) - 1
+		}
+		if start > end {
+			start = end
+		}
+		return source.Span{Start: offsets[start], End: offsets[end]}
+	}
+	displayRange := t.FullDisplayTextRange()
+	spans := []source.Span{byteSpan(Indices{0, displayRange[0]}), byteSpan(Indices{displayRange[1], len(offsets) - 1})}
+	entities := t.FullEntities()
+	for _, url := range entities.URLs {
+		spans = append(spans, byteSpan(url.Indices))
+	}
+	for _, media := range entities.Media {
+		spans = append(spans, byteSpan(media.Indices))
+	}
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i].Start < spans[j].Start
+	})
+	merged := []source.Span{}
+	for _, span := range spans {
+		if span.End <= span.Start {
+			continue
+		}
+		if last := len(merged) - 1; last >= 0 && span.Start <= merged[last].End {
+			if span.End > merged[last].End {
+				merged[last].End = span.End
+			}
+			continue
+		}
+		merged = append(merged, span)
+	}
+	return merged
+}
+
+// CleanText returns the displayed part of the tweet's full text with links and media removed using the tweet's entities, so only the words of the tweet are left
+func (t *Tweet) CleanText() string {
+	removed := t.removedSpans()
+	clean := strings.Builder{}
+	lastSpace := true
+	for i, char := range t.FullText() {
+		for len(removed) > 0 && removed[0].End <= i {
+			removed = removed[1:]
+		}
+		if len(removed) > 0 && removed[0].Start <= i {
+			continue
+		}
+		isSpace := char == ' ' || char == '\t'
+		if isSpace && lastSpace {
+			// collapse the spaces left around a removed entity
+			continue
+		}
+		clean.WriteRune(char)
+		lastSpace = isSpace || char == '\n'
+	}
+	return strings.TrimSpace(clean.String())
+}
@@ -4,31 +4,87 @@ package twitter
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"sync"
+	"time"
 
 	"github.com/antipasta/wildhaiku/config"
+	"github.com/antipasta/wildhaiku/logging"
+	"github.com/antipasta/wildhaiku/source"
 	"github.com/gomodule/oauth1/oauth"
 	"github.com/pkg/errors"
 )
 
-// Streamer is responsible for connecting to and reading from a Twitter public API stream
+// StreamEvent is something counted as the stream is read, see Streamer.OnStreamEvent
+type StreamEvent string
+
+const (
+	// TweetReceived is a tweet read off of the stream, before it is filtered
+	TweetReceived StreamEvent = "received"
+	// KeepaliveReceived is a keepalive newline sent by an otherwise idle stream
+	KeepaliveReceived StreamEvent = "keepalive"
+	// TweetDroppedLanguage is a tweet dropped for not being in english
+	TweetDroppedLanguage StreamEvent = "dropped_language"
+	// TweetDroppedEmpty is a tweet dropped for having no text once links and media are removed
+	TweetDroppedEmpty StreamEvent = "dropped_empty"
+	// RetweetReceived is a retweet, which is processed as the tweet it retweets
+	RetweetReceived StreamEvent = "retweet"
+	// ParseError is a line of the stream that could not be decoded
+	ParseError StreamEvent = "parse_error"
+	// DeletionDropped is a deleted or withheld tweet that was not reported, as nothing was reading the DeletionChannel and it was full
+	DeletionDropped StreamEvent = "deletion_dropped"
+)
+
+// StreamEventFunc is called with every StreamEvent, so they can be counted
+type StreamEventFunc func(event StreamEvent)
+
+// Streamer is responsible for connecting to and reading from a Twitter public API stream. Implements source.Source, source.Deleter and source.StatusReporter
+// Create it with NewStreamer, which sets up its credentials from config. Channels left unset are created when Run starts
 type Streamer struct {
 	Config         *config.WildHaiku
 	ConsumerKeys   *oauth.Credentials
 	Token          *oauth.Credentials
 	Client         *oauth.Client
 	httpClient     *http.Client
-	ProcessChannel chan *Tweet
+	ProcessChannel chan *source.Post
+	// APIBaseURL is the base URL of the v2 API, defaults to DefaultAPIBaseURL
+	APIBaseURL string
+	// IdleTimeout is how long the stream can send nothing before reconnecting, defaults to DefaultIdleTimeout
+	IdleTimeout time.Duration
+	// OnReconnect, if set, is called with every reconnect so they can be counted
+	OnReconnect ReconnectFunc
+	// OnControl, if set, is called with every control message, ie limit notices and stall warnings
+	OnControl ControlFunc
+	// OnStreamEvent, if set, is called as tweets and keepalives are read, and as tweets are dropped
+	OnStreamEvent StreamEventFunc
+	// Lookup, if set, is used to fetch the full text of truncated tweets while Run is streaming. Lookups are made in the background, so that they do not slow the stream down
+	Lookup LookupClient
+	// LookupWorkers is how many lookups can be made at once, defaults to DefaultLookupWorkers
+	LookupWorkers int
+	// LookupInterval is the least time between two lookups, defaults to DefaultLookupInterval
+	LookupInterval time.Duration
+	// Logger is where connection changes, control messages and undecodable lines are logged, defaults to slog.Default()
+	Logger *slog.Logger
+	// DeletionChannel receives deleted and withheld tweets, which should be removed from the archive
+	DeletionChannel chan *source.Deletion
+	sleep           func(context.Context, time.Duration) bool
+	statusLock      sync.Mutex
+	status          source.Status
+	// v2 is set when the stream is in the v2 format, see UsesV2
+	v2 bool
+	// lookupChannel holds truncated tweets waiting for Lookup, see send
+	lookupChannel chan *Tweet
 }
 
 // NewStreamer returns a twitter.Streamer object
 func NewStreamer(cfg *config.WildHaiku) *Streamer {
-	processChannel := make(chan *Tweet, 10000)
+	processChannel := make(chan *source.Post, 10000)
 	consumerKeys := oauth.Credentials{
 		Token:  cfg.ConsumerKey,
 		Secret: cfg.ConsumerSecret,
@@ -44,18 +100,42 @@ func NewStreamer(cfg *config.WildHaiku) *Streamer {
 		Credentials:                   consumerKeys,
 	}
 	ts := Streamer{
-		Config:         cfg,
-		ConsumerKeys:   &consumerKeys,
-		Token:          &token,
-		Client:         &client,
-		httpClient:     &http.Client{},
-		ProcessChannel: processChannel,
+		Config:          cfg,
+		ConsumerKeys:    &consumerKeys,
+		Token:           &token,
+		Client:          &client,
+		httpClient:      &http.Client{},
+		ProcessChannel:  processChannel,
+		DeletionChannel: make(chan *source.Deletion, 10000),
+		IdleTimeout:     DefaultIdleTimeout,
+		lookupChannel:   make(chan *Tweet, lookupQueueSize),
+		Logger:          slog.Default(),
+		sleep:           source.Sleep,
+		v2:              cfg.BearerToken != "",
+	}
+	if cfg.LookupTruncatedTweets {
+		ts.Lookup = NewAPILookupClient(&ts)
 	}
 	return &ts
 }
 
-// Connect connects to a Twitter public API stream and returns the response for reading
+// NewParser returns a twitter.Streamer that is only used to parse lines of a saved stream with ParsePost, in the v2 format if v2 is set, otherwise in the v1.1 format.
+// The format does not depend on whether config has a BearerToken, and truncated tweets are never looked up, so that parsing makes no API calls
+func NewParser(cfg *config.WildHaiku, v2 bool) *Streamer {
+	ts := NewStreamer(cfg)
+	ts.v2 = v2
+	ts.Lookup = nil
+	return ts
+}
+
+// Connect connects to a Twitter public API stream and returns the response for reading. The v2 filtered stream is used if a BearerToken is configured, otherwise the v1.1 statuses/filter stream
 func (ts *Streamer) Connect() (*http.Response, error) {
+	if ts.UsesV2() {
+		return ts.connectV2()
+	}
+	if ts.Client == nil {
+		return nil, errors.Errorf("No oauth client to connect to twitter stream with, create the streamer with NewStreamer")
+	}
 	resp, err := ts.Client.Post(
 		ts.httpClient,
 		ts.Token,
@@ -69,13 +149,18 @@ func (ts *Streamer) Connect() (*http.Response, error) {
 
 	if resp.StatusCode != http.StatusOK {
 		all, _ := ioutil.ReadAll(resp.Body)
-		return nil, errors.Errorf("Received non-OK error code [%v] [%v] when connecting to twitter stream: %v", resp.StatusCode, resp.Status, string(all))
-
+		resp.Body.Close()
+		return nil, errors.Wrapf(&StatusError{StatusCode: resp.StatusCode, Status: resp.Status, Body: string(all)}, "Error connecting to twitter stream")
 	}
 	return resp, nil
 }
 
-// StreamLoop reads off of a JSON stream of public tweets and sends json decoded Tweets to the ProcessChannel
+// Posts returns the channel that tweets are emitted on as source.Posts
+func (ts *Streamer) Posts() <-chan *source.Post {
+	return ts.ProcessChannel
+}
+
+// StreamLoop reads off of a JSON stream of public tweets and sends json decoded Tweets to the ProcessChannel as source.Posts. Truncated tweets are queued for Lookup if it is set
 func (ts *Streamer) StreamLoop(stream io.Reader) error {
 	buf := bufio.NewReader(stream)
 	for {
@@ -86,10 +171,33 @@ func (ts *Streamer) StreamLoop(stream io.Reader) error {
 		if t == nil {
 			continue
 		}
-		ts.ProcessChannel <- t
+		ts.send(t)
 	}
 }
 
+// Status reports whether the streamer is connected, and when it last read a tweet, keepalive or control message
+func (ts *Streamer) Status() source.Status {
+	ts.statusLock.Lock()
+	defer ts.statusLock.Unlock()
+	return ts.status
+}
+
+// setConnected records whether the streamer is connected, for Status
+func (ts *Streamer) setConnected(connected bool) {
+	ts.statusLock.Lock()
+	ts.status.Connected = connected
+	ts.statusLock.Unlock()
+}
+
+// ParsePost decodes a single line of a tweet stream into a source.Post. Returns nil if the line is not an english tweet with text
+func (ts *Streamer) ParsePost(line []byte) (*source.Post, error) {
+	t, err := ts.parseTweet(line)
+	if err != nil || t == nil {
+		return nil, err
+	}
+	return t.Post(), nil
+}
+
 func (ts *Streamer) tweetFromInput(reader *bufio.Reader) (*Tweet, error) {
 	inBytes, err := reader.ReadBytes('\n')
 	if err == io.EOF {
@@ -98,9 +206,13 @@ func (ts *Streamer) tweetFromInput(reader *bufio.Reader) (*Tweet, error) {
 	if len(inBytes) == 0 {
 		return nil, errors.Errorf("No bytes received")
 	}
+	ts.statusLock.Lock()
+	ts.status.LastMessage = time.Now()
+	ts.statusLock.Unlock()
 	if string(inBytes) == "\r\n" {
 		// return nil and keep going
-		log.Printf("Got keepalive ping")
+		logging.OrDefault(ts.Logger).Debug("Got keepalive ping")
+		ts.event(KeepaliveReceived)
 		return nil, nil
 	}
 	if err != nil {
@@ -111,21 +223,51 @@ func (ts *Streamer) tweetFromInput(reader *bufio.Reader) (*Tweet, error) {
 }
 
 func (ts *Streamer) parseTweet(inBytes []byte) (*Tweet, error) {
-	t := Tweet{}
-	err := json.Unmarshal(inBytes, &t)
+	if ts.UsesV2() {
+		return ts.parseV2Tweet(inBytes)
+	}
+	msg := streamMessage{}
+	err := json.Unmarshal(inBytes, &msg)
 	if err != nil {
-		log.Printf("Error json decoding line [%v]: %v", string(inBytes), err)
-		return nil, errors.Errorf("Error json decoding line [%v]: %v", string(inBytes), err)
+		logging.OrDefault(ts.Logger).Warn("Error json decoding line", "line", logging.Truncate(inBytes), "length", len(inBytes), "error", err)
+		ts.event(ParseError)
+		return nil, errors.Errorf("Error json decoding line [%v]: %v", logging.Truncate(inBytes), err)
 	}
+	if msg.ControlMessage.isSet() {
+		ts.handleControl(msg.ControlMessage)
+		return nil, nil
+	}
+	ts.event(TweetReceived)
+	t := msg.Tweet
 	if t.RetweetedStatus != nil {
+		ts.event(RetweetReceived)
 		// A standard retweet does not have any additional text, may as well work off original for proper attribution
+		timestampMS := t.TimestampMS
 		t = *t.RetweetedStatus
+		if t.TimestampMS == "" {
+			// keep when the retweet came over the stream, only the outermost tweet has a timestamp_ms
+			t.TimestampMS = timestampMS
+		}
 	}
-	if t.FullText() == "" {
-		return nil, nil
-	}
+	return ts.filterTweet(t), nil
+}
+
+// filterTweet returns the tweet if it is an english tweet with text. Returns nil otherwise
+func (ts *Streamer) filterTweet(t Tweet) *Tweet {
 	if t.Lang != "en" {
-		return nil, nil
+		ts.event(TweetDroppedLanguage)
+		return nil
+	}
+	if t.CleanText() == "" {
+		ts.event(TweetDroppedEmpty)
+		return nil
+	}
+	return &t
+}
+
+// event calls OnStreamEvent, if set
+func (ts *Streamer) event(event StreamEvent) {
+	if ts.OnStreamEvent != nil {
+		ts.OnStreamEvent(event)
 	}
-	return &t, nil
 }
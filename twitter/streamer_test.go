@@ -1,16 +1,32 @@
 package twitter
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
 	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/antipasta/wildhaiku/config"
+	"github.com/antipasta/wildhaiku/source"
 )
 
 func TestStreamer(t *testing.T) {
 	testCfg := config.WildHaiku{}
 	s := NewStreamer(&testCfg)
+	limits := 0
+	s.OnControl = func(cm ControlMessage) {
+		if cm.Limit != nil {
+			limits++
+		}
+	}
 	tweetFile, err := os.Open("sampletweets.json")
 	if err != nil {
 		t.Errorf("Error opening sampletweets.json %v", err)
@@ -23,17 +39,507 @@ func TestStreamer(t *testing.T) {
 		// 108 tweets  in file are non english or have no text in tweet body
 		t.Errorf("expected 591 tweets in channel, got %v", len(s.ProcessChannel))
 	}
+	if limits != 13 {
+		t.Errorf("expected 13 limit notices, got %v", limits)
+	}
 	for len(s.ProcessChannel) > 0 {
-		tweet := <-s.ProcessChannel
-		if tweet == nil {
+		post := <-s.ProcessChannel
+		if post == nil {
 			t.Errorf("Got nil tweet on channel")
 		}
-		if tweet.Lang != "en" {
-			t.Errorf("Got non english language tweet %s", tweet.Lang)
+		if post.Language != "en" {
+			t.Errorf("Got non english language tweet %s", post.Language)
 		}
-		if tweet.FullText() == "" {
+		if post.Text == "" {
 			t.Errorf("Got tweet with empty tweet body")
 		}
+		if _, isTweet := post.Original.(*Tweet); !isTweet {
+			t.Errorf("Expected post to keep original tweet, got %+v", post.Original)
+		}
+	}
+
+}
+
+func TestStreamerV2(t *testing.T) {
+	ruleRequests := []map[string]interface{}{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer testtoken" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.URL.Path == "/2/tweets/search/stream/rules" && r.Method == http.MethodGet:
+			fmt.Fprintf(w, `{"data":[{"id":"1","value":"old lang:en -is:retweet"},{"id":"2","value":%q}]}`, ruleValue("the"))
+		case r.URL.Path == "/2/tweets/search/stream/rules" && r.Method == http.MethodPost:
+			body := map[string]interface{}{}
+			json.NewDecoder(r.Body).Decode(&body)
+			ruleRequests = append(ruleRequests, body)
+			if added, _ := json.Marshal(body["add"]); strings.Contains(string(added), "pond") {
+				fmt.Fprintf(w, `{"meta":{"summary":{"created":0,"not_created":1}},"errors":[{"value":%q,"title":"DuplicateRule"}]}`, ruleValue("pond"))
+				return
+			}
+			fmt.Fprint(w, `{"meta":{"summary":{"created":1,"deleted":1}}}`)
+		case r.URL.Path == "/2/tweets/search/stream":
+			fmt.Fprint(w, "\r\n")
+			fmt.Fprint(w, `{"data":{"id":"10","text":"a tweet about the sea","lang":"en","author_id":"99"},"includes":{"users":[{"id":"99","username":"haikufan"}]}}`+"\r\n")
+			fmt.Fprint(w, `{"data":{"id":"11","text":"un tweet","lang":"fr","author_id":"99"}}`+"\r\n")
+			fmt.Fprint(w, `{"data":{"id":"12","text":"short","note_tweet":{"text":"a much longer note tweet"},"lang":"en","author_id":"98"}}`+"\r\n")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	testCfg := config.WildHaiku{BearerToken: "testtoken", TrackingKeywords: []string{"the", "sea", "sea"}}
+	s := NewStreamer(&testCfg)
+	s.APIBaseURL = server.URL
+	resp, err := s.Connect()
+	if err != nil {
+		t.Fatalf("Error connecting to v2 stream %v", err)
+	}
+	defer resp.Body.Close()
+	if len(ruleRequests) != 2 {
+		t.Fatalf("Expected a delete and an add rules request, got %+v", ruleRequests)
+	}
+	deleteIDs := ruleRequests[0]["delete"].(map[string]interface{})["ids"].([]interface{})
+	if len(deleteIDs) != 1 || deleteIDs[0] != "1" {
+		t.Errorf("Expected rule 1 to be deleted, got %+v", ruleRequests[0])
+	}
+	addRules := ruleRequests[1]["add"].([]interface{})
+	if len(addRules) != 1 || addRules[0].(map[string]interface{})["value"] != ruleValue("sea") {
+		t.Errorf("Expected rule for sea to be added, got %+v", ruleRequests[1])
+	}
+
+	err = s.StreamLoop(resp.Body)
+	if err != nil && err != io.EOF {
+		t.Errorf("Error when streaming %v", err)
+	}
+	if len(s.ProcessChannel) != 2 {
+		t.Fatalf("expected 2 tweets in channel, got %v", len(s.ProcessChannel))
+	}
+	post := <-s.ProcessChannel
+	if post.ID != "10" || post.Author != "haikufan" || post.Text != "a tweet about the sea" || post.Permalink != "https://twitter.com/haikufan/status/10" {
+		t.Errorf("Tweet not mapped from v2 envelope correctly %+v", post)
+	}
+	post = <-s.ProcessChannel
+	if post.Text != "a much longer note tweet" {
+		t.Errorf("Expected note tweet text to be used as full text, got %s", post.Text)
+	}
+
+	testCfg.TrackingKeywords = []string{"the", "pond"}
+	if err := s.SyncRules(); err == nil || !strings.Contains(err.Error(), "DuplicateRule") {
+		t.Errorf("Expected rules rejected with an OK status to be an error, got %v", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	b := newBackoff()
+	expected := []struct {
+		reason ReconnectReason
+		wait   time.Duration
+	}{
+		{ReconnectNetworkError, 250 * time.Millisecond},
+		{ReconnectNetworkError, 500 * time.Millisecond},
+		{ReconnectHTTPError, 5 * time.Second},
+		{ReconnectHTTPError, 10 * time.Second},
+		{ReconnectRateLimited, time.Minute},
+		{ReconnectRateLimited, 2 * time.Minute},
+		{ReconnectStall, 250 * time.Millisecond},
+	}
+	for i, e := range expected {
+		if _, wait := b.next(e.reason); wait != e.wait {
+			t.Errorf("Expected reconnect %d for %s to wait %v, got %v", i, e.reason, e.wait, wait)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		b.next(ReconnectNetworkError)
+		b.next(ReconnectHTTPError)
+		b.next(ReconnectRateLimited)
+	}
+	if _, wait := b.next(ReconnectNetworkError); wait != MaxNetworkBackoff {
+		t.Errorf("Expected network backoff to be capped at %v, got %v", MaxNetworkBackoff, wait)
+	}
+	if _, wait := b.next(ReconnectHTTPError); wait != MaxHTTPBackoff {
+		t.Errorf("Expected http backoff to be capped at %v, got %v", MaxHTTPBackoff, wait)
+	}
+	if _, wait := b.next(ReconnectRateLimited); wait != MaxRateLimitBackoff {
+		t.Errorf("Expected rate limit backoff to be capped at %v, got %v", MaxRateLimitBackoff, wait)
+	}
+	b.reset()
+	if attempt, wait := b.next(ReconnectRateLimited); attempt != 1 || wait != RateLimitBackoffStart {
+		t.Errorf("Expected backoff to restart after reset, got attempt %d wait %v", attempt, wait)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	streamStatuses := []int{http.StatusTooManyRequests, 420, http.StatusServiceUnavailable, http.StatusOK}
+	connects := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/2/tweets/search/stream/rules":
+			fmt.Fprintf(w, `{"data":[{"id":"1","value":%q}]}`, ruleValue("the"))
+		case "/2/tweets/search/stream":
+			status := streamStatuses[connects]
+			connects++
+			if status != http.StatusOK {
+				w.WriteHeader(status)
+				return
+			}
+			fmt.Fprint(w, `{"data":{"id":"10","text":"a tweet about the sea","lang":"en"}}`+"\r\n")
+			w.(http.Flusher).Flush()
+			// stall without closing the connection
+			<-r.Context().Done()
+		}
+	}))
+	defer server.Close()
+
+	s := NewStreamer(&config.WildHaiku{BearerToken: "testtoken", TrackingKeywords: []string{"the"}})
+	s.APIBaseURL = server.URL
+	s.IdleTimeout = 50 * time.Millisecond
+	b := newBackoff()
+	expected := []ReconnectEvent{
+		{Reason: ReconnectRateLimited, Attempt: 1, Wait: RateLimitBackoffStart},
+		{Reason: ReconnectRateLimited, Attempt: 2, Wait: 2 * RateLimitBackoffStart},
+		{Reason: ReconnectHTTPError, Attempt: 1, Wait: HTTPBackoffStart},
+		{Reason: ReconnectStall, Attempt: 1, Wait: NetworkBackoffStep},
+	}
+	for i, e := range expected {
+		event := s.connectAndStream(context.Background(), b)
+		if event.Reason != e.Reason || event.Attempt != e.Attempt || event.Wait != e.Wait || event.Err == nil {
+			t.Errorf("Expected reconnect %d to be %+v, got %+v", i, e, event)
+		}
+	}
+	if len(s.ProcessChannel) != 1 {
+		t.Errorf("Expected tweet sent before the stall to be processed, got %v", len(s.ProcessChannel))
+	}
+	if b.attempts[ReconnectRateLimited] != 0 {
+		t.Errorf("Expected successful connection to reset backoff, got %+v", b.attempts)
+	}
+}
+
+func TestControlMessages(t *testing.T) {
+	stream := strings.Join([]string{
+		`{"delete":{"status":{"id":1234,"id_str":"1234","user_id":3,"user_id_str":"3"},"timestamp_ms":"1556822710929"}}`,
+		`{"limit":{"track":52,"timestamp_ms":"1556822710929"}}`,
+		`{"id_str":"1235","lang":"en","text":"a tweet between notices"}`,
+		`{"warning":{"code":"FALLING_BEHIND","message":"Your connection is falling behind","percent_full":60}}`,
+		`{"status_withheld":{"id":1236,"user_id":4,"withheld_in_countries":["DE"]}}`,
+		`{"disconnect":{"code":4,"stream_name":"haiku-statuses","reason":"duplicate stream"}}`,
+	}, "\r\n") + "\r\n"
+	s := NewStreamer(&config.WildHaiku{})
+	messages := []ControlMessage{}
+	s.OnControl = func(cm ControlMessage) {
+		messages = append(messages, cm)
+	}
+	err := s.StreamLoop(strings.NewReader(stream))
+	if err != nil && err != io.EOF {
+		t.Errorf("Error when streaming %v", err)
+	}
+	if len(s.ProcessChannel) != 1 {
+		t.Errorf("Expected only the tweet to be processed, got %v", len(s.ProcessChannel))
+	}
+	if len(messages) != 5 {
+		t.Fatalf("Expected 5 control messages, got %+v", messages)
+	}
+	if messages[0].Delete == nil || messages[1].Limit == nil || messages[2].Warning == nil || messages[3].StatusWithheld == nil || messages[4].Disconnect == nil {
+		t.Errorf("Control messages not decoded to their types %+v", messages)
+	}
+	if messages[1].Limit.Track != 52 || messages[2].Warning.PercentFull != 60 || messages[4].Disconnect.Code != 4 {
+		t.Errorf("Control message fields not decoded %+v %+v %+v", messages[1].Limit, messages[2].Warning, messages[4].Disconnect)
+	}
+	if len(s.DeletionChannel) != 2 {
+		t.Fatalf("Expected 2 deletions, got %v", len(s.DeletionChannel))
+	}
+	deletion := <-s.Deletions()
+	if deletion.ID != "1234" || deletion.Reason != "delete" {
+		t.Errorf("Unexpected deletion %+v", deletion)
+	}
+	deletion = <-s.Deletions()
+	if deletion.ID != "1236" || deletion.Reason != "withheld" {
+		t.Errorf("Unexpected withheld deletion %+v", deletion)
+	}
+
+	// nothing reads an unbuffered DeletionChannel, so the deletes are dropped instead of stalling the stream
+	s.DeletionChannel = make(chan *source.Deletion)
+	dropped := 0
+	s.OnStreamEvent = func(event StreamEvent) {
+		if event == DeletionDropped {
+			dropped++
+		}
+	}
+	err = s.StreamLoop(strings.NewReader(stream))
+	if err != nil && err != io.EOF {
+		t.Errorf("Error when streaming %v", err)
+	}
+	if dropped != 2 {
+		t.Errorf("Expected 2 dropped deletions, got %v", dropped)
+	}
+}
+
+func TestTweetModel(t *testing.T) {
+	line := `{"created_at":"Thu May 02 18:45:10 +0000 2019","id_str":"20","lang":"en",` +
+		`"user":{"id_str":"7","name":"Matsuo Bashō","screen_name":"basho","verified":true},` +
+		`"source":"<a href=\"https:\/\/ifttt.com\" rel=\"nofollow\">IFTTT<\/a>",` +
+		`"text":"@bob @carol an old silent pond 🐸 https://t.co/abc &amp; a frog jumps in https://t.co/pic",` +
+		`"display_text_range":[12,71],` +
+		`"entities":{"urls":[{"url":"https://t.co/abc","expanded_url":"https://example.com","indices":[33,49]}],` +
+		`"user_mentions":[{"screen_name":"bob","id_str":"1","indices":[0,4]},{"screen_name":"carol","id_str":"2","indices":[5,11]}],` +
+		`"hashtags":[],"media":[{"id_str":"5","type":"photo","url":"https://t.co/pic","indices":[72,88]}]},` +
+		`"in_reply_to_status_id_str":"19","in_reply_to_user_id_str":"1","in_reply_to_screen_name":"bob",` +
+		`"quoted_status_id_str":"18","quoted_status":{"id_str":"18","lang":"en","text":"the sound of water","user":{"screen_name":"issa"}}}`
+	s := NewStreamer(&config.WildHaiku{})
+	post, err := s.ParsePost([]byte(line))
+	if err != nil || post == nil {
+		t.Fatalf("Error parsing tweet %v", err)
+	}
+	tweet := post.Original.(*Tweet)
+	if post.Text != tweet.Text {
+		t.Errorf("Expected post text to be the tweet text, got %q", post.Text)
+	}
+	if text := tweet.CleanText(); text != "an old silent pond 🐸 &amp; a frog jumps in" {
+		t.Errorf("Expected reply mentions, links and media to be cleaned from text, got %q", text)
+	}
+	removed := []string{}
+	for _, span := range post.Removed {
+		removed = append(removed, post.Text[span.Start:span.End])
+	}
+	if strings.Join(removed, "|") != "@bob @carol |https://t.co/abc| https://t.co/pic" {
+		t.Errorf("Expected reply mentions, links and media to be removed spans of the post text, got %q", removed)
+	}
+	if post.Timestamp.Format(time.RFC3339) != "2019-05-02T18:45:10Z" {
+		t.Errorf("Expected timestamp from created_at, got %v", post.Timestamp)
+	}
+	if tweet.User.IDStr != "7" || tweet.User.Name != "Matsuo Bashō" || !tweet.User.Verified {
+		t.Errorf("User not decoded %+v", tweet.User)
+	}
+	if !tweet.IsReply() || tweet.InReplyToScreenName != "bob" || !tweet.IsQuote() || tweet.QuotedStatus.FullText() != "the sound of water" {
+		t.Errorf("Reply and quote context not decoded %+v", tweet)
+	}
+	if !tweet.IsAutomated() {
+		t.Errorf("Expected tweet from IFTTT to be automated")
+	}
+	archived, err := json.Marshal(tweet)
+	if err != nil || !strings.Contains(string(archived), `"created_at":"Thu May 02 18:45:10 +0000 2019"`) {
+		t.Errorf("Expected created_at to be archived in its original format, got %s %v", archived, err)
+	}
+
+	extended := Tweet{
+		Text:          "cut short… https://t.co/more",
+		Entities:      Entities{URLs: []URLEntity{{URL: "https://t.co/more", Indices: Indices{11, 28}}}},
+		ExtendedTweet: &ExtendedTweet{FullText: "cut short by the stream https://t.co/x", Entities: Entities{URLs: []URLEntity{{Indices: Indices{24, 38}}}}},
+	}
+	if text := extended.CleanText(); text != "cut short by the stream" {
+		t.Errorf("Expected extended entities to clean extended text, got %q", text)
+	}
+}
+
+// fakeLookup returns tweets from a map, counting lookups
+type fakeLookup struct {
+	tweets  map[string]*Tweet
+	lookups int
+}
+
+func (fl *fakeLookup) Lookup(id string) (*Tweet, error) {
+	fl.lookups++
+	if t, found := fl.tweets[id]; found {
+		return t, nil
+	}
+	return nil, fmt.Errorf("tweet %s not found", id)
+}
+
+func TestTruncation(t *testing.T) {
+	testCases := []struct {
+		tweet     Tweet
+		truncated bool
+	}{
+		{Tweet{Text: "an old silent pond"}, false},
+		{Tweet{Text: "an old silent po…", Truncated: true}, true},
+		{Tweet{Text: "an old silent po… https://t.co/x", Truncated: true, ExtendedTweet: &ExtendedTweet{FullText: "an old silent pond, a frog jumps in"}}, false},
+		{Tweet{Text: "RT @basho: an old silent po…"}, true},
+		{Tweet{Text: "an old silent po… https://t.co/x"}, true},
+		{Tweet{Text: "an old silent pond, and then…"}, false},
+	}
+	for _, testCase := range testCases {
+		if testCase.tweet.IsTruncated() != testCase.truncated {
+			t.Errorf("Expected %+v truncated to be %v", testCase.tweet, testCase.truncated)
+		}
 	}
 
+	stream := `{"id_str":"1","lang":"en","truncated":true,"text":"an old silent pond. a frog ju… https://t.co/x"}` + "\r\n" +
+		`{"id_str":"2","lang":"en","truncated":true,"text":"the sound of wa… https://t.co/y"}` + "\r\n"
+	lookup := &fakeLookup{tweets: map[string]*Tweet{"1": {IDStr: "1", Text: "an old silent pond. a frog jumps in, the sound of water"}}}
+	s := NewStreamer(&config.WildHaiku{})
+	s.Lookup = lookup
+	s.LookupWorkers = 1
+	s.LookupInterval = time.Millisecond
+	lookupsDone := make(chan struct{})
+	go func() {
+		s.runLookups(context.Background())
+		close(lookupsDone)
+	}()
+	err := s.StreamLoop(strings.NewReader(stream))
+	if err != nil && err != io.EOF {
+		t.Errorf("Error when streaming %v", err)
+	}
+	close(s.lookupChannel)
+	<-lookupsDone
+	if lookup.lookups != 2 {
+		t.Errorf("Expected both truncated tweets to be looked up, got %d lookups", lookup.lookups)
+	}
+	post := <-s.ProcessChannel
+	if post.Truncated || post.Text != "an old silent pond. a frog jumps in, the sound of water" {
+		t.Errorf("Expected full text from lookup, got %+v", post)
+	}
+	post = <-s.ProcessChannel
+	if !post.Truncated {
+		t.Errorf("Expected tweet that failed lookup to be marked truncated, got %+v", post)
+	}
+
+	// with nothing taking tweets off of the lookup queue, truncated tweets are processed without waiting for their full text
+	s = NewStreamer(&config.WildHaiku{})
+	s.Lookup = lookup
+	s.lookupChannel = make(chan *Tweet)
+	err = s.StreamLoop(strings.NewReader(stream))
+	if err != nil && err != io.EOF {
+		t.Errorf("Error when streaming %v", err)
+	}
+	if len(s.ProcessChannel) != 2 || lookup.lookups != 2 {
+		t.Errorf("Expected both tweets to be processed without a lookup when the queue is full, got %d posts and %d lookups", len(s.ProcessChannel), lookup.lookups)
+	}
+}
+
+func TestAPILookupClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/2/tweets/1" || r.Header.Get("Authorization") != "Bearer testtoken" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"data":{"id":"1","text":"short","lang":"en","note_tweet":{"text":"a much longer note tweet"}}}`)
+	}))
+	defer server.Close()
+	s := NewStreamer(&config.WildHaiku{BearerToken: "testtoken", LookupTruncatedTweets: true})
+	s.APIBaseURL = server.URL
+	tweet, err := s.Lookup.Lookup("1")
+	if err != nil {
+		t.Fatalf("Error looking up tweet: %v", err)
+	}
+	if tweet.FullText() != "a much longer note tweet" {
+		t.Errorf("Expected full text of looked up tweet, got %q", tweet.FullText())
+	}
+	if _, err = s.Lookup.Lookup("2"); err == nil {
+		t.Errorf("Expected error looking up missing tweet")
+	}
+}
+
+func TestRunDefaults(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := (&Streamer{Config: &config.WildHaiku{}}).Connect(); err == nil {
+		t.Errorf("Expected an error connecting without an oauth client")
+	}
+	s := &Streamer{Config: &config.WildHaiku{BearerToken: "testtoken"}, APIBaseURL: server.URL, v2: true}
+	if err := s.Run(ctx); err != nil {
+		t.Errorf("Expected nil from Run with a done context, got %v", err)
+	}
+	if _, open := <-s.Posts(); open {
+		t.Errorf("Expected Run to create and close the posts channel")
+	}
+	if _, open := <-s.Deletions(); open {
+		t.Errorf("Expected Run to create and close the deletions channel")
+	}
+}
+
+func TestRunStopsOnContext(t *testing.T) {
+	sent := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/2/tweets/search/stream/rules":
+			fmt.Fprintf(w, `{"data":[{"id":"1","value":%q}]}`, ruleValue("the"))
+		case "/2/tweets/search/stream":
+			fmt.Fprint(w, `{"data":{"id":"10","text":"a tweet about the sea","lang":"en"}}`+"\r\n")
+			w.(http.Flusher).Flush()
+			close(sent)
+			<-r.Context().Done()
+		}
+	}))
+	defer server.Close()
+
+	s := NewStreamer(&config.WildHaiku{BearerToken: "testtoken", TrackingKeywords: []string{"the"}})
+	s.APIBaseURL = server.URL
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.Run(ctx)
+	}()
+	<-sent
+	for start := time.Now(); !s.Status().Connected && time.Since(start) < 5*time.Second; {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status := s.Status(); !status.Connected || status.LastMessage.IsZero() {
+		t.Errorf("Expected a connected status with a last message while streaming, got %+v", status)
+	}
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected nil from Run after cancel, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Run did not return after context was canceled")
+	}
+	posts := 0
+	for range s.Posts() {
+		posts++
+	}
+	if posts != 1 {
+		t.Errorf("Expected the streamed tweet and a closed channel, got %d posts", posts)
+	}
+	if _, open := <-s.Deletions(); open {
+		t.Errorf("Expected deletion channel to be closed")
+	}
+	if s.Status().Connected {
+		t.Errorf("Expected status to be disconnected once Run returns")
+	}
+}
+
+func TestStreamEvents(t *testing.T) {
+	stream := strings.Join([]string{
+		`{"id_str":"1","lang":"en","text":"a tweet about the sea"}`,
+		``,
+		`{"id_str":"2","lang":"fr","text":"un tweet sur la mer"}`,
+		`{"id_str":"3","lang":"en","text":"https://t.co/abc","entities":{"urls":[{"url":"https://t.co/abc","indices":[0,16]}]}}`,
+		`{"limit":{"track":52,"timestamp_ms":"1556822710929"}}`,
+		`{"id_str":"4","lang":"en","text":"RT @sea: the sea","retweeted_status":{"id_str":"5","lang":"en","text":"the sea"}}`,
+		`{"id_str":`,
+	}, "\r\n") + "\r\n"
+	s := NewStreamer(&config.WildHaiku{})
+	logged := bytes.Buffer{}
+	s.Logger = slog.New(slog.NewTextHandler(&logged, nil))
+	events := map[StreamEvent]int{}
+	s.OnStreamEvent = func(event StreamEvent) {
+		events[event]++
+	}
+	if err := s.StreamLoop(strings.NewReader(stream)); err == nil || err == io.EOF {
+		t.Errorf("Expected a decoding error from the final line, got %v", err)
+	}
+	expected := map[StreamEvent]int{TweetReceived: 4, KeepaliveReceived: 1, TweetDroppedLanguage: 1, TweetDroppedEmpty: 1, RetweetReceived: 1, ParseError: 1}
+	for event, count := range expected {
+		if events[event] != count {
+			t.Errorf("Expected %d %s events, got %d", count, event, events[event])
+		}
+	}
+	if len(s.ProcessChannel) != 2 {
+		t.Errorf("Expected 2 tweets to be processed, got %d", len(s.ProcessChannel))
+	}
+	if strings.Contains(logged.String(), "keepalive") || !strings.Contains(logged.String(), "Error json decoding line") {
+		t.Errorf("Expected keepalives to only be logged at debug level, and decoding errors at info level and above, got %v", logged.String())
+	}
+	s.Logger = nil
+	if _, err := s.parseTweet([]byte(`{"id_str":`)); err == nil {
+		t.Errorf("Expected a decoding error with the default logger, got %v", err)
+	}
 }
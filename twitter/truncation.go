@@ -0,0 +1,170 @@
+package twitter
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antipasta/wildhaiku/logging"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultLookupWorkers is how many truncated tweets can be looked up at once
+	DefaultLookupWorkers = 4
+	// DefaultLookupInterval is the least time between two lookups, keeping within the 900 requests per 15 minutes allowed by statuses/show and /2/tweets
+	DefaultLookupInterval = time.Second
+	// DefaultLookupTimeout is how long a lookup can take before it is given up on
+	DefaultLookupTimeout = 10 * time.Second
+	// lookupQueueSize is how many truncated tweets can wait to be looked up before they are processed without their full text
+	lookupQueueSize = 1000
+)
+
+// IsTruncated checks if the tweet's full text was cut short: Twitter marked it truncated without sending the extended tweet, or it is retweet text or text followed by a t.co link that ends in a truncation mark.
+// Retweet text and v2 tweets have no truncated flag, but a tweet of its own that ends in "…" with no link after it was written that way
+func (t *Tweet) IsTruncated() bool {
+	if t.ExtendedTweet != nil && len(t.ExtendedTweet.FullText) > 0 {
+		return false
+	}
+	if t.Truncated {
+		return true
+	}
+	fields := strings.Fields(t.Text)
+	linked := false
+	for len(fields) > 0 && strings.HasPrefix(fields[len(fields)-1], "https://t.co/") {
+		fields = fields[:len(fields)-1]
+		linked = true
+	}
+	if !linked && !strings.HasPrefix(t.Text, "RT @") {
+		return false
+	}
+	return len(fields) > 0 && strings.HasSuffix(fields[len(fields)-1], "…")
+}
+
+// LookupClient fetches a tweet by ID. Used to get the full text of truncated tweets
+type LookupClient interface {
+	Lookup(id string) (*Tweet, error)
+}
+
+// APILookupClient looks tweets up with the v2 API if the streamer has a BearerToken, otherwise with v1.1 statuses/show
+type APILookupClient struct {
+	Streamer *Streamer
+	// HTTPClient makes the lookup requests, with a timeout so that a slow response does not hold up a lookup worker
+	HTTPClient *http.Client
+}
+
+// NewAPILookupClient returns an APILookupClient for the streamer's credentials, timing out after DefaultLookupTimeout
+func NewAPILookupClient(ts *Streamer) *APILookupClient {
+	return &APILookupClient{Streamer: ts, HTTPClient: &http.Client{Timeout: DefaultLookupTimeout}}
+}
+
+// v1ExtendedTweet is a tweet from the v1.1 REST API in extended mode, where the full text and its display range are top level fields
+type v1ExtendedTweet struct {
+	Tweet
+	FullText string `json:"full_text"`
+}
+
+// Lookup fetches the tweet with the given ID, with its full text
+func (lc *APILookupClient) Lookup(id string) (*Tweet, error) {
+	ts := lc.Streamer
+	if ts.UsesV2() {
+		query := url.Values{"tweet.fields": []string{"lang,author_id,note_tweet,created_at,entities"}}
+		resp, err := ts.v2RequestWith(lc.HTTPClient, http.MethodGet, "/2/tweets/"+url.PathEscape(id)+"?"+query.Encode(), nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error looking up tweet %s", id)
+		}
+		defer resp.Body.Close()
+		envelope := v2Envelope{}
+		err = json.NewDecoder(resp.Body).Decode(&envelope)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error json decoding tweet %s", id)
+		}
+		if envelope.Data == nil {
+			return nil, errors.Errorf("Tweet %s not found", id)
+		}
+		t := envelope.tweet()
+		return &t, nil
+	}
+	resp, err := ts.Client.Get(lc.HTTPClient, ts.Token, ts.apiURL("/1.1/statuses/show.json"),
+		url.Values{"id": []string{id}, "tweet_mode": []string{"extended"}})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error looking up tweet %s", id)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Wrapf(&StatusError{StatusCode: resp.StatusCode, Status: resp.Status}, "Error looking up tweet %s", id)
+	}
+	extended := v1ExtendedTweet{}
+	err = json.NewDecoder(resp.Body).Decode(&extended)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error json decoding tweet %s", id)
+	}
+	t := extended.Tweet
+	if extended.FullText != "" {
+		t.ExtendedTweet = &ExtendedTweet{FullText: extended.FullText, DisplayTextRange: t.DisplayTextRange, Entities: t.Entities}
+	}
+	return &t, nil
+}
+
+// send sends a tweet to the ProcessChannel, or queues it to have its full text looked up first if it is truncated and Lookup is set.
+// A truncated tweet is sent as it is if the lookup queue is full, so that lookups never slow down reading the stream
+func (ts *Streamer) send(t *Tweet) {
+	if ts.Lookup != nil && t.IsTruncated() {
+		select {
+		case ts.lookupChannel <- t:
+			return
+		default:
+			logging.OrDefault(ts.Logger).Warn("Lookup queue is full, processing truncated tweet without its full text", "id", t.IDStr)
+		}
+	}
+	ts.ProcessChannel <- t.Post()
+}
+
+// runLookups completes the truncated tweets queued by send on LookupWorkers workers, starting at most one lookup every LookupInterval, and sends them to the ProcessChannel.
+// Returns once lookupChannel is closed and drained. Once ctx is done, queued tweets are sent without being looked up
+func (ts *Streamer) runLookups(ctx context.Context) {
+	workers := ts.LookupWorkers
+	if workers <= 0 {
+		workers = DefaultLookupWorkers
+	}
+	interval := ts.LookupInterval
+	if interval <= 0 {
+		interval = DefaultLookupInterval
+	}
+	limiter := time.NewTicker(interval)
+	defer limiter.Stop()
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range ts.lookupChannel {
+				select {
+				case <-ctx.Done():
+				case <-limiter.C:
+					ts.completeTruncated(t)
+				}
+				ts.ProcessChannel <- t.Post()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// completeTruncated replaces the text of a truncated tweet with its full text from the Lookup client. The tweet is left as is if the lookup fails
+func (ts *Streamer) completeTruncated(t *Tweet) {
+	full, err := ts.Lookup.Lookup(t.IDStr)
+	if err != nil {
+		logging.OrDefault(ts.Logger).Warn("Error looking up full text of truncated tweet", "id", t.IDStr, "error", err)
+		return
+	}
+	if full.IsTruncated() {
+		return
+	}
+	displayRange := full.FullDisplayTextRange()
+	t.ExtendedTweet = &ExtendedTweet{FullText: full.FullText(), DisplayTextRange: &displayRange, Entities: full.FullEntities()}
+}
@@ -2,20 +2,87 @@
  */
 package twitter
 
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antipasta/wildhaiku/source"
+)
+
+// CreatedAtLayout is the time format of created_at in Twitter's v1.1 API
+const CreatedAtLayout = time.RubyDate
+
+// CreatedAt is a time encoded in Twitter's v1.1 created_at format
+type CreatedAt struct {
+	time.Time
+}
+
+// UnmarshalJSON parses a created_at string, leaving the time zero if it is null or empty
+func (c *CreatedAt) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil || value == "" {
+		return nil
+	}
+	parsed, err := time.Parse(CreatedAtLayout, value)
+	if err != nil {
+		return err
+	}
+	c.Time = parsed
+	return nil
+}
+
+// MarshalJSON writes the time back in created_at format, so archived tweets look like they did on the stream
+func (c CreatedAt) MarshalJSON() ([]byte, error) {
+	if c.IsZero() {
+		return []byte(`""`), nil
+	}
+	return json.Marshal(c.Format(CreatedAtLayout))
+}
+
+// User is a representation of a subset of fields of a Twitter user
+type User struct {
+	IDStr      string `json:"id_str"`
+	Name       string `json:"name"`
+	ScreenName string `json:"screen_name"`
+	Verified   bool   `json:"verified"`
+}
+
 // Tweet is a representation of a subset of fields of a Tweet from Twitter's API
 type Tweet struct {
-	IDStr string `json:"id_str"`
-	Lang  string `json:"lang"`
-	User  struct {
-		ScreenName string `json:"screen_name"`
-	} `json:"user"`
-	Text          string `json:"text,omitempty"`
-	ExtendedTweet *struct {
-		FullText string `json:"full_text,omitempty"`
-	} `json:"extended_tweet,omitempty"`
-	RetweetedStatus *Tweet `json:"retweeted_status,omitempty"`
+	CreatedAt CreatedAt `json:"created_at"`
+	IDStr     string    `json:"id_str"`
+	Lang      string    `json:"lang"`
+	User      User      `json:"user"`
+	// Source is the html link to the app that sent the tweet
+	Source           string   `json:"source,omitempty"`
+	Text             string   `json:"text,omitempty"`
+	DisplayTextRange *Indices `json:"display_text_range,omitempty"`
+	Entities         Entities `json:"entities"`
+	// Truncated is set when Text is cut short and the full text is in ExtendedTweet
+	Truncated            bool           `json:"truncated,omitempty"`
+	ExtendedTweet        *ExtendedTweet `json:"extended_tweet,omitempty"`
+	InReplyToStatusIDStr string         `json:"in_reply_to_status_id_str,omitempty"`
+	InReplyToUserIDStr   string         `json:"in_reply_to_user_id_str,omitempty"`
+	InReplyToScreenName  string         `json:"in_reply_to_screen_name,omitempty"`
+	QuotedStatusIDStr    string         `json:"quoted_status_id_str,omitempty"`
+	QuotedStatus         *Tweet         `json:"quoted_status,omitempty"`
+	RetweetedStatus      *Tweet         `json:"retweeted_status,omitempty"`
+	TimestampMS          string         `json:"timestamp_ms,omitempty"`
+}
+
+// ExtendedTweet holds the untruncated text of a Tweet longer than 140 characters, along with the entities and display range of that text
+type ExtendedTweet struct {
+	FullText         string   `json:"full_text,omitempty"`
+	DisplayTextRange *Indices `json:"display_text_range,omitempty"`
+	Entities         Entities `json:"entities"`
 }
 
+// automationApps are names of apps that tweets are sent from by bots
+var automationApps = []string{"twittbot.net", "IFTTT", "dlvr.it", "Cheap Bots, Done Quick!", "Botbird"}
+
 // FullText returns the full text of the tweet. t.ExtendedTweet.FullText if it exists, else t.Text
 func (t *Tweet) FullText() string {
 	if t.ExtendedTweet != nil && len(t.ExtendedTweet.FullText) > 0 {
@@ -23,3 +90,53 @@ func (t *Tweet) FullText() string {
 	}
 	return t.Text
 }
+
+// Permalink returns the URL of the tweet on twitter.com
+func (t *Tweet) Permalink() string {
+	return fmt.Sprintf("https://twitter.com/%s/status/%s", t.User.ScreenName, t.IDStr)
+}
+
+// IsReply checks if the tweet is a reply to another tweet
+func (t *Tweet) IsReply() bool {
+	return t.InReplyToStatusIDStr != ""
+}
+
+// IsQuote checks if the tweet quotes another tweet
+func (t *Tweet) IsQuote() bool {
+	return t.QuotedStatusIDStr != "" || t.QuotedStatus != nil
+}
+
+// IsAutomated checks if the tweet was sent from an app used to run bots
+func (t *Tweet) IsAutomated() bool {
+	for _, app := range automationApps {
+		if strings.Contains(t.Source, ">"+app+"<") {
+			return true
+		}
+	}
+	return false
+}
+
+// Time returns when the tweet was sent over the stream, from its timestamp_ms, falling back to created_at. Zero if the tweet has neither
+func (t *Tweet) Time() time.Time {
+	ms, err := strconv.ParseInt(t.TimestampMS, 10, 64)
+	if err != nil {
+		return t.CreatedAt.Time
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC()
+}
+
+// Post converts the tweet to a source.Post, keeping the tweet's full text so that offsets into the post are offsets into the tweet, and the tweet as the post's Original.
+// The parts of the text that CleanText removes are the post's Removed spans
+func (t *Tweet) Post() *source.Post {
+	return &source.Post{
+		ID:        t.IDStr,
+		Author:    t.User.ScreenName,
+		Permalink: t.Permalink(),
+		Text:      t.FullText(),
+		Removed:   t.removedSpans(),
+		Language:  t.Lang,
+		Timestamp: t.Time(),
+		Truncated: t.IsTruncated(),
+		Original:  t,
+	}
+}
@@ -0,0 +1,325 @@
+package twitter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/antipasta/wildhaiku/logging"
+	"github.com/pkg/errors"
+)
+
+// DefaultAPIBaseURL is the base URL of Twitter's v2 API
+const DefaultAPIBaseURL = "https://api.twitter.com"
+
+// V2Rule is a filtered stream rule, as returned by and sent to the v2 stream rules endpoint
+type V2Rule struct {
+	ID    string `json:"id,omitempty"`
+	Value string `json:"value"`
+	Tag   string `json:"tag,omitempty"`
+}
+
+// v2Entities are the entities of a v2 tweet's text, with start and end indices instead of v1.1 style index pairs
+type v2Entities struct {
+	URLs []struct {
+		Start       int    `json:"start"`
+		End         int    `json:"end"`
+		URL         string `json:"url"`
+		ExpandedURL string `json:"expanded_url"`
+		DisplayURL  string `json:"display_url"`
+	} `json:"urls"`
+	Mentions []struct {
+		Start    int    `json:"start"`
+		End      int    `json:"end"`
+		Username string `json:"username"`
+		ID       string `json:"id"`
+	} `json:"mentions"`
+	Hashtags []struct {
+		Start int    `json:"start"`
+		End   int    `json:"end"`
+		Tag   string `json:"tag"`
+	} `json:"hashtags"`
+}
+
+// v1Entities converts v2 entities to v1.1 Entities
+func (e *v2Entities) v1Entities() Entities {
+	entities := Entities{}
+	for _, url := range e.URLs {
+		entities.URLs = append(entities.URLs, URLEntity{URL: url.URL, ExpandedURL: url.ExpandedURL, DisplayURL: url.DisplayURL, Indices: Indices{url.Start, url.End}})
+	}
+	for _, mention := range e.Mentions {
+		entities.UserMentions = append(entities.UserMentions, MentionEntity{ScreenName: mention.Username, IDStr: mention.ID, Indices: Indices{mention.Start, mention.End}})
+	}
+	for _, hashtag := range e.Hashtags {
+		entities.Hashtags = append(entities.Hashtags, HashtagEntity{Text: hashtag.Tag, Indices: Indices{hashtag.Start, hashtag.End}})
+	}
+	return entities
+}
+
+// v2Envelope is the subset of a v2 filtered stream message needed to build a Tweet
+type v2Envelope struct {
+	Data *struct {
+		ID               string     `json:"id"`
+		Text             string     `json:"text"`
+		Lang             string     `json:"lang"`
+		AuthorID         string     `json:"author_id"`
+		CreatedAt        time.Time  `json:"created_at"`
+		InReplyToUserID  string     `json:"in_reply_to_user_id"`
+		Entities         v2Entities `json:"entities"`
+		ReferencedTweets []struct {
+			Type string `json:"type"`
+			ID   string `json:"id"`
+		} `json:"referenced_tweets"`
+		NoteTweet *struct {
+			Text     string     `json:"text"`
+			Entities v2Entities `json:"entities"`
+		} `json:"note_tweet,omitempty"`
+	} `json:"data"`
+	Includes struct {
+		Users []struct {
+			ID       string `json:"id"`
+			Name     string `json:"name"`
+			Username string `json:"username"`
+			Verified bool   `json:"verified"`
+		} `json:"users"`
+	} `json:"includes"`
+	Errors []struct {
+		Title  string `json:"title"`
+		Detail string `json:"detail"`
+	} `json:"errors"`
+}
+
+// UsesV2 checks if the streamer is configured to use the v2 filtered stream, which is the case when a BearerToken is set, or a parser was created for the v2 format
+func (ts *Streamer) UsesV2() bool {
+	return ts.v2
+}
+
+// ruleValue returns the v2 stream rule for a tracking keyword, matching original english tweets containing it
+func ruleValue(keyword string) string {
+	return fmt.Sprintf("%s lang:en -is:retweet", keyword)
+}
+
+func (ts *Streamer) apiURL(path string) string {
+	baseURL := ts.APIBaseURL
+	if baseURL == "" {
+		baseURL = DefaultAPIBaseURL
+	}
+	return baseURL + path
+}
+
+// v2Request sends an authenticated request to the v2 API, returning the response if it has an OK status
+func (ts *Streamer) v2Request(method string, path string, body interface{}) (*http.Response, error) {
+	return ts.v2RequestWith(ts.httpClient, method, path, body)
+}
+
+// v2RequestWith sends an authenticated request to the v2 API with client, returning the response if it has an OK status
+func (ts *Streamer) v2RequestWith(client *http.Client, method string, path string, body interface{}) (*http.Response, error) {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyBytes, err := json.Marshal(body)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error marshalling json for %+v", body)
+		}
+		bodyReader = bytes.NewReader(bodyBytes)
+	}
+	req, err := http.NewRequest(method, ts.apiURL(path), bodyReader)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error creating request for %s", path)
+	}
+	req.Header.Set("Authorization", "Bearer "+ts.Config.BearerToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Caught error when requesting %s", path)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		all, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, errors.Wrapf(&StatusError{StatusCode: resp.StatusCode, Status: resp.Status, Body: string(all)}, "Error requesting %s", path)
+	}
+	return resp, nil
+}
+
+// Rules returns the filtered stream rules currently set for the bearer token
+func (ts *Streamer) Rules() ([]V2Rule, error) {
+	resp, err := ts.v2Request(http.MethodGet, "/2/tweets/search/stream/rules", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	rulesResp := struct {
+		Data []V2Rule `json:"data"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&rulesResp)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error json decoding stream rules")
+	}
+	return rulesResp.Data, nil
+}
+
+// v2RulesResponse is the result of adding or deleting filtered stream rules. Rules that fail, ie as duplicates or for invalid syntax, are reported in Errors with an OK status
+type v2RulesResponse struct {
+	Meta struct {
+		Summary struct {
+			Created    int `json:"created"`
+			NotCreated int `json:"not_created"`
+			Deleted    int `json:"deleted"`
+			NotDeleted int `json:"not_deleted"`
+		} `json:"summary"`
+	} `json:"meta"`
+	Errors []struct {
+		Value  string `json:"value"`
+		ID     string `json:"id"`
+		Title  string `json:"title"`
+		Detail string `json:"detail"`
+	} `json:"errors"`
+}
+
+// changeRules sends a request adding or deleting filtered stream rules, errors if any rule could not be changed
+func (ts *Streamer) changeRules(body interface{}) (*v2RulesResponse, error) {
+	resp, err := ts.v2Request(http.MethodPost, "/2/tweets/search/stream/rules", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	rulesResp := v2RulesResponse{}
+	err = json.NewDecoder(resp.Body).Decode(&rulesResp)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error json decoding stream rules response")
+	}
+	if len(rulesResp.Errors) > 0 {
+		failures := make([]string, len(rulesResp.Errors))
+		for i, ruleErr := range rulesResp.Errors {
+			rule := ruleErr.Value
+			if rule == "" {
+				rule = ruleErr.ID
+			}
+			failures[i] = fmt.Sprintf("%s: %s", ruleErr.Title, rule)
+		}
+		return &rulesResp, errors.Errorf("%d stream rules failed: %s", len(failures), strings.Join(failures, ", "))
+	}
+	return &rulesResp, nil
+}
+
+// SyncRules makes the filtered stream rules match config TrackingKeywords, deleting any rules that do not correspond to a keyword and adding a rule for each keyword that is missing one.
+// Errors if any rule could not be deleted or added
+func (ts *Streamer) SyncRules() error {
+	existing, err := ts.Rules()
+	if err != nil {
+		return err
+	}
+	wanted := map[string]bool{}
+	for _, keyword := range ts.Config.TrackingKeywords {
+		wanted[ruleValue(keyword)] = true
+	}
+	deleteIDs := []string{}
+	for _, rule := range existing {
+		if wanted[rule.Value] {
+			delete(wanted, rule.Value)
+			continue
+		}
+		deleteIDs = append(deleteIDs, rule.ID)
+	}
+	deleted, added := 0, 0
+	if len(deleteIDs) > 0 {
+		rulesResp, err := ts.changeRules(map[string]interface{}{"delete": map[string][]string{"ids": deleteIDs}})
+		if err != nil {
+			return errors.Wrapf(err, "Error deleting stream rules %v", deleteIDs)
+		}
+		deleted = rulesResp.Meta.Summary.Deleted
+	}
+	if len(wanted) > 0 {
+		addRules := []V2Rule{}
+		for _, keyword := range ts.Config.TrackingKeywords {
+			if value := ruleValue(keyword); wanted[value] {
+				// a keyword listed more than once is only added once
+				delete(wanted, value)
+				addRules = append(addRules, V2Rule{Value: value, Tag: keyword})
+			}
+		}
+		rulesResp, err := ts.changeRules(map[string]interface{}{"add": addRules})
+		if err != nil {
+			return errors.Wrapf(err, "Error adding stream rules %+v", addRules)
+		}
+		added = rulesResp.Meta.Summary.Created
+	}
+	logging.OrDefault(ts.Logger).Info("Synced stream rules", "deleted", deleted, "added", added)
+	return nil
+}
+
+// connectV2 syncs stream rules and connects to the v2 filtered stream, returning the response for reading
+func (ts *Streamer) connectV2() (*http.Response, error) {
+	err := ts.SyncRules()
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{
+		"tweet.fields": []string{"lang,author_id,note_tweet,created_at,entities,in_reply_to_user_id,referenced_tweets"},
+		"expansions":   []string{"author_id"},
+		"user.fields":  []string{"username,name,verified"},
+	}
+	resp, err := ts.v2Request(http.MethodGet, "/2/tweets/search/stream?"+query.Encode(), nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Caught error when connecting to twitter stream")
+	}
+	return resp, nil
+}
+
+// tweet maps the envelope's data and includes onto a Tweet. The envelope must have Data
+func (envelope *v2Envelope) tweet() Tweet {
+	t := Tweet{
+		IDStr:              envelope.Data.ID,
+		Lang:               envelope.Data.Lang,
+		Text:               envelope.Data.Text,
+		CreatedAt:          CreatedAt{envelope.Data.CreatedAt},
+		Entities:           envelope.Data.Entities.v1Entities(),
+		InReplyToUserIDStr: envelope.Data.InReplyToUserID,
+	}
+	t.User.IDStr = envelope.Data.AuthorID
+	if envelope.Data.NoteTweet != nil && envelope.Data.NoteTweet.Text != "" {
+		t.ExtendedTweet = &ExtendedTweet{FullText: envelope.Data.NoteTweet.Text, Entities: envelope.Data.NoteTweet.Entities.v1Entities()}
+	}
+	for _, referenced := range envelope.Data.ReferencedTweets {
+		switch referenced.Type {
+		case "replied_to":
+			t.InReplyToStatusIDStr = referenced.ID
+		case "quoted":
+			t.QuotedStatusIDStr = referenced.ID
+		}
+	}
+	for _, user := range envelope.Includes.Users {
+		if user.ID == envelope.Data.AuthorID {
+			t.User.ScreenName = user.Username
+			t.User.Name = user.Name
+			t.User.Verified = user.Verified
+		}
+	}
+	return t
+}
+
+// parseV2Tweet maps a v2 filtered stream message's data and includes onto a Tweet
+func (ts *Streamer) parseV2Tweet(inBytes []byte) (*Tweet, error) {
+	envelope := v2Envelope{}
+	err := json.Unmarshal(inBytes, &envelope)
+	if err != nil {
+		logging.OrDefault(ts.Logger).Warn("Error json decoding line", "line", logging.Truncate(inBytes), "length", len(inBytes), "error", err)
+		ts.event(ParseError)
+		return nil, errors.Errorf("Error json decoding line [%v]: %v", logging.Truncate(inBytes), err)
+	}
+	if envelope.Data == nil {
+		if len(envelope.Errors) > 0 {
+			logging.OrDefault(ts.Logger).Warn("Got stream error", "title", envelope.Errors[0].Title, "detail", envelope.Errors[0].Detail)
+		}
+		return nil, nil
+	}
+	ts.event(TweetReceived)
+	return ts.filterTweet(envelope.tweet()), nil
+}